@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"log"
+)
+
+// Exit code of a run when an assertion fails
+const exitAssertion = 2
+
+// checkAssertions checks SLO assertions against the run, displays a
+// pass/fail summary and records results. It returns false when an
+// assertion fails.
+func checkAssertions(a pgcheetah.Assertions) bool {
+
+	assertions := pgcheetah.Check(run, a)
+	if len(assertions) == 0 {
+		return true
+	}
+	passed := true
+	log.Print("Assertions:\n")
+	for _, r := range assertions {
+		result := "PASS"
+		if !r.Passed {
+			result = "FAIL"
+			passed = false
+		}
+		fmt.Fprintf(report, "%s	%s %g	- %.3f\n", result, r.Name, r.Limit, r.Value)
+		record(pgcheetah.RecordAssertion, r)
+	}
+	if passed {
+		log.Print("All assertions passed\n")
+	} else {
+		log.Print("Assertion failed\n")
+	}
+	return passed
+}
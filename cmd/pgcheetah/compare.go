@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"log"
+	"os"
+)
+
+// Exit code of compare command when a regression is found
+const exitRegression = 2
+
+// compare implements "pgcheetah compare base.ndjson new.ndjson". It displays
+// side by side results of two runs written with -output ndjson and flags
+// regressions. It returns the exit code.
+func compare(args []string) int {
+
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var t pgcheetah.Thresholds
+	fs.Float64Var(&t.TPS, "tpsthreshold", 5, "TPS/QPS decrease in percent flagged as regression")
+	fs.Float64Var(&t.Latency, "latencythreshold", 10, "Latency increase in percent flagged as regression")
+	fs.Float64Var(&t.ErrorRate, "errorratethreshold", 1, "Error rate increase in percentage points flagged as regression")
+	fs.Float64Var(&t.WaitEvent, "waiteventthreshold", 5, "Wait event share increase in percentage points flagged as regression")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [options] base.ndjson new.ndjson\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	base, err := pgcheetah.LoadResults(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error during results loading %s", err)
+	}
+	cur, err := pgcheetah.LoadResults(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Error during results loading %s", err)
+	}
+
+	fmt.Printf("Base: %s (%s)\n", fs.Arg(0), base.Metadata.Time.Format("2006-01-02 15:04:05"))
+	fmt.Printf("New:  %s (%s)\n\n", fs.Arg(1), cur.Metadata.Time.Format("2006-01-02 15:04:05"))
+	if diff := pgcheetah.ManifestDiff(base.Manifest, cur.Manifest); len(diff) > 0 {
+		fmt.Println("Run environment differs:")
+		for _, d := range diff {
+			fmt.Println("  " + d)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%-45s %12s %12s %9s %8s\n", "Metric", "Base", "New", "Change", "Noise")
+
+	regressions := 0
+	for _, c := range pgcheetah.Compare(base, cur, t) {
+		change := fmt.Sprintf("%+.1f%s", c.Change, c.Unit)
+		noise := ""
+		if c.Noise != 0 {
+			noise = fmt.Sprintf("±%.1f%%", c.Noise)
+		}
+		mark := ""
+		if c.Regression {
+			mark = "REGRESSION"
+			regressions++
+		}
+		fmt.Printf("%-45s %12.2f %12.2f %9s %8s  %s\n", c.Metric, c.Base, c.New, change, noise, mark)
+	}
+
+	if regressions > 0 {
+		fmt.Printf("\n%d regression(s) found\n", regressions)
+		return exitRegression
+	}
+	fmt.Println("\nNo regression found")
+	return 0
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// clientGroup is a client group of the scenario with its dataset, counters
+// and throttling state.
+type clientGroup struct {
+	pgcheetah.ClientGroup
+	clients     int
+	connStr     string
+	think       pgcheetah.ThinkTime
+	tps         float64 // Target tps before scaling, 0 when not throttled
+	counters    *pgcheetah.GroupCounters
+	delayXactUs int
+	step        int
+	prevXact    int64
+}
+
+// Client groups of the scenario, empty without groups
+var groups []*clientGroup
+
+// Sum of target tps of groups. Target tps of the test is split between
+// groups in proportion of their target, it can be changed by phases or TUI.
+var groupsTPS float64
+
+// newClientGroups applies defaults of command line options to groups and
+// parses their datasets.
+func newClientGroups(cfgs []pgcheetah.ClientGroup) error {
+
+	for _, cfg := range cfgs {
+		g := &clientGroup{ClientGroup: cfg, clients: *clients, connStr: *connStr,
+			think:    pgcheetah.ThinkTime{Distribution: "uniform", Min: *thinkTimeMin, Max: *thinkTimeMax},
+			counters: pgcheetah.NewGroupCounters(), delayXactUs: delayXactUs, step: 10}
+		if cfg.Clients != nil {
+			g.clients = *cfg.Clients
+		}
+		if cfg.ConnStr != "" {
+			g.connStr = cfg.ConnStr
+		}
+		if cfg.ThinkTime.Min != nil {
+			g.think.Min = *cfg.ThinkTime.Min
+		}
+		if cfg.ThinkTime.Max != nil {
+			g.think.Max = *cfg.ThinkTime.Max
+		}
+		if cfg.TPS != nil {
+			g.tps = *cfg.TPS
+			groupsTPS += g.tps
+		}
+		if err := parseDataset(cfg.QueryFile); err != nil {
+			return fmt.Errorf("group %s: %s", cfg.Name, err)
+		}
+		groups = append(groups, g)
+	}
+	return nil
+}
+
+// groupTarget returns the target tps of a group, 0 when not throttled.
+func groupTarget(g *clientGroup) float64 {
+	if groupsTPS == 0 {
+		return 0
+	}
+	return g.tps * getTargetTPS() / groupsTPS
+}
+
+// launchGroups starts clients of all groups, their start is spread among
+// delaystart seconds.
+func launchGroups() {
+
+	total := 0
+	for _, g := range groups {
+		total += g.clients
+	}
+	pause := time.Duration(*delayStart*1000/total) * time.Millisecond
+	for _, g := range groups {
+		w := datasetWorker(g.QueryFile)
+		w.ApplicationName = pgcheetah.GroupApplicationName(g.Name)
+		w.ConnStr = &g.connStr
+		w.DelayXactUs = &g.delayXactUs
+		w.Group = g.counters
+		w.Think = &g.think
+		startWorkers(w, g.clients, pause)
+	}
+}
+
+// throttleGroups adjusts delay between transactions of each throttled
+// group, it is called every 100ms by rateLimiter.
+func throttleGroups() {
+	for _, g := range groups {
+		xact := atomic.LoadInt64(&g.counters.Xact)
+		// Counters are reset when measurement starts after warmup
+		if xact < g.prevXact {
+			g.prevXact = 0
+		}
+		if target := groupTarget(g); target != 0 {
+			g.delayXactUs, g.step = throttle(float64(xact-g.prevXact)*10, target, g.delayXactUs, g.step)
+		}
+		g.prevXact = xact
+	}
+}
+
+// reportGroups displays and records stats of each group over the
+// measurement, and wait events of each group.
+func reportGroups() {
+
+	run.Lock()
+	end := run.Summary.Time
+	run.Unlock()
+	log.Print("Client groups:\n")
+	fmt.Fprintf(report, "%-20s %8s %10s %10s %8s %10s %10s %10s %10s\n", "group", "clients", "target", "xact", "errors",
+		"tps", "p50 ms", "p95 ms", "p99 ms")
+	for _, g := range groups {
+		counters := g.counters.Counters()
+		counters.Time = end
+		st := pgcheetah.NewGroupStats(g.Name, pgcheetah.PhaseCounters{Time: measureStart}, counters)
+		st.Clients = g.clients
+		st.TargetTPS = groupTarget(g)
+		record(pgcheetah.RecordGroup, st)
+		fmt.Fprintf(report, "%-20s %8d %10.f %10d %8d %10.f %10.3f %10.3f %10.3f\n", st.Group, st.Clients, st.TargetTPS,
+			st.Xact, st.Errors, st.TPS, st.LatencyP50, st.LatencyP95, st.LatencyP99)
+	}
+
+	now := time.Now()
+	for _, srv := range servers {
+		if srv.groupWaitEvent == nil {
+			continue
+		}
+		for _, l := range srv.groupWaitEvent.Labels() {
+			group, event := pgcheetah.SplitGroupWaitEvent(l)
+			record(pgcheetah.RecordGroupWaitEvent, pgcheetah.WaitEventStat{Time: now, Elapsed: time.Since(start).Seconds(),
+				Server: srv.label, Event: event, Count: int(srv.groupWaitEvent.Total(l)), Group: group})
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"github.com/jackc/pgx/v4"
+	"log"
+	"strings"
+	"time"
+)
+
+// stringList is a flag which can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// monitoredServer contains collectors and statistics snapshots of a server
+// watched during the test. It can be the server under load, a replica or the
+// server behind a pooler.
+type monitoredServer struct {
+	label           string
+	connStr         string
+	db              *pgx.Conn
+	waitEvent       *pgcheetah.WaitEvents
+	groupWaitEvent  *pgcheetah.Metric // Wait events by client group, only with groups
+	lockStats       *pgcheetah.LockStats
+	metrics         []*pgcheetah.Metric
+	startStats      pgcheetah.ServerStats
+	startStatements pgcheetah.StatementsSnapshot
+	prevWaitEvent   map[string]int // Wait events count at previous interval report
+	baseWaitEvent   map[string]int // Wait events count at measurement start
+}
+
+// newMonitoredServer opens the monitoring connection of a server.
+// Server is labelled with its host, port and database.
+func newMonitoredServer(connStr string, queries []pgcheetah.MetricQuery) (*monitoredServer, error) {
+
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	label := fmt.Sprintf("%s:%d/%s", cfg.Host, cfg.Port, cfg.Database)
+	for _, srv := range servers {
+		if srv.label == label {
+			label = fmt.Sprintf("%s#%d", label, len(servers))
+		}
+	}
+
+	db, err := pgcheetah.Connect(connStr)
+	if err != nil {
+		return nil, err
+	}
+	srv := monitoredServer{label: label, connStr: connStr, db: db, waitEvent: pgcheetah.NewWaitEvents(), lockStats: pgcheetah.NewLockStats()}
+	for _, q := range queries {
+		srv.metrics = append(srv.metrics, pgcheetah.NewMetric(q))
+	}
+	return &srv, nil
+}
+
+// snapshot takes statistics snapshots at measurement start.
+func (srv *monitoredServer) snapshot() error {
+
+	var err error
+	srv.startStats, err = pgcheetah.TakeSnapshot(srv.db)
+	if err != nil {
+		return fmt.Errorf("statistics snapshot: %s", err)
+	}
+	if *pgss {
+		srv.startStatements, err = pgcheetah.TakeStatementsSnapshot(srv.db)
+		if err != nil {
+			return fmt.Errorf("pg_stat_statements snapshot: %s", err)
+		}
+	}
+	return nil
+}
+
+// start takes statistics snapshots and starts collectors.
+func (srv *monitoredServer) start() error {
+
+	if err := srv.snapshot(); err != nil {
+		return err
+	}
+
+	go pgcheetah.WaitEventCollector(srv.waitEvent, &srv.connStr, *weInterval)
+	if len(groups) > 0 {
+		version, err := pgcheetah.ServerVersion(srv.db)
+		if err != nil {
+			return fmt.Errorf("server version: %s", err)
+		}
+		srv.groupWaitEvent = pgcheetah.NewMetric(pgcheetah.GroupWaitEventQuery(version, *weInterval))
+		go pgcheetah.MetricCollector(srv.groupWaitEvent, &srv.connStr)
+	}
+	if *locks {
+		go pgcheetah.LockCollector(srv.lockStats, &srv.connStr, *lockInterval)
+	}
+	for _, m := range srv.metrics {
+		go pgcheetah.MetricCollector(m, &srv.connStr)
+	}
+	return nil
+}
+
+// reset starts measurement again after warmup: wait events and lock waits
+// sampled so far and metrics are discarded, statistics snapshots are taken
+// again.
+func (srv *monitoredServer) reset() error {
+	srv.baseWaitEvent = srv.waitEvent.Snapshot()
+	srv.lockStats.Reset()
+	if srv.groupWaitEvent != nil {
+		srv.groupWaitEvent.Reset()
+	}
+	for _, m := range srv.metrics {
+		m.Reset()
+	}
+	return srv.snapshot()
+}
+
+// header returns a log prefix identifying the server when several servers are monitored.
+func (srv *monitoredServer) header() string {
+	if len(servers) > 1 {
+		return "[" + srv.label + "] "
+	}
+	return ""
+}
+
+// report displays wait events, lock waits, user defined metrics and
+// statistics deltas collected during the test.
+func (srv *monitoredServer) report() error {
+
+	now := time.Now()
+	log.Printf("%sWait_event count:\n", srv.header())
+	for w, c := range srv.waitEvent.Snapshot() {
+		if c -= srv.baseWaitEvent[w]; c <= 0 {
+			continue
+		}
+		fmt.Fprintf(report, "%s	- %d\n", w, c)
+		record(pgcheetah.RecordWaitEvent, pgcheetah.WaitEventStat{Time: now, Elapsed: time.Since(start).Seconds(),
+			Server: srv.label, Event: w, Count: c})
+	}
+
+	if *locks {
+		srv.reportLocks()
+	}
+	srv.reportMetrics()
+
+	endStats, err := pgcheetah.TakeSnapshot(srv.db)
+	if err != nil {
+		return fmt.Errorf("statistics snapshot: %s", err)
+	}
+	delta := endStats.Delta(srv.startStats)
+	log.Printf("%sServer statistics:\n", srv.header())
+	for _, k := range delta.Keys() {
+		fmt.Fprintf(report, "%s	- %d\n", k, delta[k])
+		record(pgcheetah.RecordServerStat, pgcheetah.ServerStat{Time: now, Server: srv.label, Name: k, Value: delta[k]})
+	}
+
+	if *pgss {
+		endStatements, err := pgcheetah.TakeStatementsSnapshot(srv.db)
+		if err != nil {
+			return fmt.Errorf("pg_stat_statements snapshot: %s", err)
+		}
+		delta := endStatements.Delta(srv.startStatements)
+		statements.Join(delta)
+		srv.reportStatements(delta)
+	}
+	return srv.db.Close(context.Background())
+}
+
+// reportInterval records wait events sampled and displays user defined
+// metrics values since previous interval report.
+func (srv *monitoredServer) reportInterval(elapsed float64) {
+	now := time.Now()
+	waitEvent := srv.waitEvent.Snapshot()
+	for w, c := range waitEvent {
+		if c > srv.prevWaitEvent[w] {
+			record(pgcheetah.RecordIntervalWaitEvent, pgcheetah.WaitEventStat{Time: now, Elapsed: elapsed,
+				Server: srv.label, Event: w, Count: c - srv.prevWaitEvent[w]})
+		}
+	}
+	srv.prevWaitEvent = waitEvent
+
+	for _, m := range srv.metrics {
+		values := m.Interval()
+		var b strings.Builder
+		for _, l := range m.Labels() {
+			if b.Len() > 0 {
+				b.WriteString(" ")
+			}
+			if l != "" {
+				b.WriteString(l + "=")
+			}
+			fmt.Fprintf(&b, "%g", values[l])
+		}
+		log.Printf("%sMetric %s: %s\n", srv.header(), m.Query.Name, b.String())
+	}
+}
+
+// reportMetrics displays user defined metrics over the whole test. Counters
+// are reported with their rate per second, gauges with min, average and max.
+func (srv *monitoredServer) reportMetrics() {
+	elapsed := time.Since(measureStart).Seconds()
+	for _, m := range srv.metrics {
+		log.Printf("%sMetric %s (%s):\n", srv.header(), m.Query.Name, m.Query.Kind)
+		for _, l := range m.Labels() {
+			m.Lock()
+			v := *m.Values[l]
+			m.Unlock()
+			switch m.Query.Kind {
+			case "counter":
+				fmt.Fprintf(report, "%s	- %g (%.2f/s)\n", l, m.Total(l), m.Total(l)/elapsed)
+			case "gauge":
+				fmt.Fprintf(report, "%s	- last: %g min: %g avg: %g max: %g\n", l, v.Last, v.Min, v.Sum/float64(v.Samples), v.Max)
+			default:
+				fmt.Fprintf(report, "%s	- %g\n", l, m.Total(l))
+			}
+		}
+	}
+}
+
+// reportLocks displays relations, modes and blocking statements which caused
+// most lock waits and the largest blocking trees sampled.
+func (srv *monitoredServer) reportLocks() {
+	ls := srv.lockStats
+	ls.Lock()
+	defer ls.Unlock()
+
+	log.Printf("%sLock waits: %d in %d samples\n", srv.header(), ls.Waits, ls.Samples)
+	log.Print("Top blocking relations:\n")
+	for _, r := range pgcheetah.Top(ls.Relations, 10) {
+		fmt.Fprintf(report, "%s	- %d\n", r, ls.Relations[r])
+	}
+	log.Print("Top lock modes waited:\n")
+	for _, m := range pgcheetah.Top(ls.Modes, 10) {
+		fmt.Fprintf(report, "%s	- %d\n", m, ls.Modes[m])
+	}
+	log.Print("Top blocking statements:\n")
+	for _, q := range pgcheetah.Top(ls.Statements, 10) {
+		fmt.Fprintf(report, "%d	- %s\n", ls.Statements[q], q)
+	}
+	if len(ls.Largest) > 0 {
+		log.Print("Largest blocking trees:\n")
+		for _, n := range ls.Largest {
+			printLockTree(n, 0)
+		}
+	}
+}
+
+// printLockTree displays a blocking tree, waiters are indented below their blocker.
+func printLockTree(n *pgcheetah.LockNode, depth int) {
+	query := strings.Join(strings.Fields(n.Query), " ")
+	if len(query) > 80 {
+		query = query[:77] + "..."
+	}
+	if depth == 0 {
+		fmt.Fprintf(report, "pid %d: %s\n", n.Pid, query)
+	} else {
+		fmt.Fprintf(report, "%s└ pid %d waits %s on %s: %s\n", strings.Repeat("  ", depth), n.Pid, n.Mode, n.Relation, query)
+	}
+	for _, w := range n.Waiters {
+		printLockTree(w, depth+1)
+	}
+}
+
+// reportStatements displays pg_stat_statements deltas of the most expensive
+// statements. Client time is the latency measured by workers, the difference
+// with execution time is network and pooler overhead.
+func (srv *monitoredServer) reportStatements(delta []pgcheetah.StatementStats) {
+	log.Printf("%spg_stat_statements:\n", srv.header())
+	fmt.Fprintf(report, "%-20s %10s %12s %10s %10s %10s %12s %12s %12s %8s  %s\n", "queryid", "calls", "total ms", "mean ms",
+		"client ms", "rows", "shared hit", "shared read", "wal bytes", "xacts", "query")
+	for i, st := range delta {
+		if i >= *pgssLimit {
+			break
+		}
+		query := strings.Join(strings.Fields(st.Query), " ")
+		if len(query) > 60 {
+			query = query[:57] + "..."
+		}
+		fmt.Fprintf(report, "%-20d %10d %12.1f %10.3f %10.3f %10d %12d %12d %12d %8d  %s\n", st.QueryID, st.Calls, st.ExecTime,
+			st.MeanExecTime(), st.MeanClientTime(), st.Rows, st.SharedBlksHit, st.SharedBlksRead, st.WalBytes,
+			st.DatasetXacts, query)
+	}
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/anayrat/pgcheetah/pkg/pgcheetah"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"io"
 	"log"
 	"math"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,12 +22,25 @@ import (
 var data = make(map[int][]string, 100000)
 
 var delayXactUs int
-var start time.Time
+var start time.Time        // Start of the test, origin of interval stats
+var measureStart time.Time // Start of measurement, after warmup phases
 var wg sync.WaitGroup
 var worker pgcheetah.Worker
 var done chan bool
+var statements *pgcheetah.StatementIndex
+var classes *pgcheetah.XactClasses
+var clientStates = &pgcheetah.ClientStates{}
+var servers []*monitoredServer
+var errorStats = pgcheetah.NewErrorStats()
+var results pgcheetah.ResultWriter
+var run = &pgcheetah.Results{}
 
 // Command line arguments
+var aggregateInterval = flag.Int("aggregateinterval", 0, "Aggregate transaction log by interval of seconds")
+var assertMaxErrorRate = flag.Float64("assertmaxerrorrate", 0, "Exit with code 2 when error rate in percent of queries is above")
+var assertMaxP99 = flag.Float64("assertmaxp99", 0, "Exit with code 2 when p99 transaction latency in ms is above")
+var assertMaxWaitEvent stringList
+var assertMinTPS = flag.Float64("assertmintps", 0, "Exit with code 2 when average tps is below")
 var clients = flag.Int("clients", 100, "number of client")
 var connStr = flag.String("constr", "user=postgres dbname=postgres", "pg connstring")
 var datasetFraction = flag.Float64("datasetfraction", 1.0, "Fraction of dataset to use between 0 - 1")
@@ -32,40 +48,159 @@ var debug = flag.Bool("debug", false, "debug mode")
 var delayStart = flag.Int("delaystart", 0, "spread client start among seconds")
 var delayXact = flag.Float64("delayxact", 5, "millisecond between each transaction")
 var duration = flag.Int("duration", 0, "Test duration in seconds")
+var htmlReport = flag.String("htmlreport", "", "Path of HTML report generated at the end of the test")
 var interval = flag.Int("interval", 1, "Interval stats report each seconds")
 var queryFile = flag.String("queryfile", "", "Path to file containing queries to play")
+var scenarioFile = flag.String("scenario", "", "Path to YAML scenario file, options given on command line take precedence")
+var seed = flag.Int64("seed", 0, "Seed of random generators to reproduce a run, random when 0")
+var selection = flag.String("selection", "uniform", "Transaction selection: uniform, weighted, zipf, sequential, timestamp or session")
+var samplingRate = flag.Float64("samplingrate", 1, "Fraction of transactions written in transaction log, between 0 and 1")
+var speed = flag.Float64("speed", 1, "Speed of timestamp selection replay, 2 replays twice faster")
 var slowStartFactor = flag.Float64("slowstartfactor", 1.6, "Factor to control how fast the delay between transaction will be changed")
+var tuiMode = flag.Bool("tui", false, "Display a live dashboard in terminal instead of log lines")
 var thinkTimeMax = flag.Int("thinktimemax", 5, "millisecond thinktime")
 var thinkTimeMin = flag.Int("thinktimemin", 5, "millisecond thinktime")
 var tps = flag.Float64("tps", 0, "Expected tps")
+var locks = flag.Bool("locks", false, "Sample lock waits and report blocking relations and statements")
+var lockInterval = flag.Int("lockinterval", 1000, "Lock waits sampling interval in ms")
+var monConnStr stringList
+var metricsFile = flag.String("metricsfile", "", "Path to YAML file containing metric queries to poll")
 var netpprof = flag.Bool("netpprof", false, "Enable internal pprof web server")
+var output = flag.String("output", "", "Write results in a machine readable format: ndjson or csv")
+var outputFile = flag.String("outputfile", "", "Path of ndjson file (- for stdout) or prefix of csv files (default pgcheetah.ndjson or pgcheetah)")
+var promAddr = flag.String("promaddr", "", "Serve Prometheus metrics on /metrics at this address, e.g. localhost:9187")
+var pgss = flag.Bool("pgss", false, "Report pg_stat_statements deltas, extension must be installed")
+var pgssLimit = flag.Int("pgsslimit", 20, "Number of statements reported with -pgss")
+var resultsConnStr = flag.String("resultsconstr", "", "pg connstring of database where results are stored in pgcheetah schema")
+var xactLogPrefix = flag.String("xactlog", "", "Write a log of each transaction in files prefix.<client id>")
+var xactClasses = flag.Bool("xactclasses", false, "Report stats by transaction fingerprint")
+var xactClassesLimit = flag.Int("xactclasseslimit", 20, "Number of transaction classes reported with -xactclasses")
+var warmup = flag.Int("warmup", 0, "Warmup duration in seconds before test duration, excluded from summary")
 var weInterval = flag.Int("weinterval", 500, "Wait Event collection interval in ms")
+var zipfExponent = flag.Float64("zipfexponent", 1.1, "Exponent of zipf selection, higher values concentrate load on fewer transactions")
 
 // Global counters
 var (
-	queriesCount int64
-	xactCount    int64
+	activeClients int64
+	currentTPS    int64
+	queriesCount  int64
+	xactCount     int64
+)
+
+// Expected tps as float64 bits, it can be changed during the test with the TUI
+var targetTPS uint64
+
+func getTargetTPS() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&targetTPS))
+}
+
+func setTargetTPS(t float64) {
+	atomic.StoreUint64(&targetTPS, math.Float64bits(t))
+}
+
+// Human readable reports, moved to stderr when ndjson output goes to stdout
+var report io.Writer = os.Stdout
+
+// Latency histograms
+var (
+	queryLatency = pgcheetah.NewHistogram()
+	xactLatency  = pgcheetah.NewHistogram()
 )
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(compare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "synthesize" {
+		os.Exit(synthesize(os.Args[2:]))
+	}
+
 	data[0] = []string{""}
-	waitEvent := make(map[string]int)
 	done = make(chan bool)
-	var timer *time.Timer
 	think := pgcheetah.ThinkTime{Distribution: "uniform", Min: 0, Max: 5}
 	s := pgcheetah.State{Statedesc: "init", Xact: 0, XactInProgress: false}
 
+	flag.Var(&assertMaxWaitEvent, "assertmaxwaitevent", "Exit with code 2 when share in percent of a wait event class is above, e.g. Lock=5, can be repeated")
+	flag.Var(&monConnStr, "monconstr", "pg connstring of a server to monitor, can be repeated (default constr)")
 	flag.Parse()
+	var phases []pgcheetah.Phase
+	var groupConfigs []pgcheetah.ClientGroup
+	if *scenarioFile != "" {
+		scenario, err := applyScenario(*scenarioFile)
+		if err != nil {
+			log.Fatalf("Error during scenario loading %s", err)
+		}
+		phases, groupConfigs = scenario.Phases, scenario.Groups
+	}
+	// Only assertions set on command line or scenario are checked, a zero
+	// limit is a valid limit
+	assertions := pgcheetah.Assertions{MaxWaitEventShare: make(map[string]float64)}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "assertmintps":
+			assertions.MinTPS = assertMinTPS
+		case "assertmaxp99":
+			assertions.MaxP99 = assertMaxP99
+		case "assertmaxerrorrate":
+			assertions.MaxErrorRate = assertMaxErrorRate
+		}
+	})
+	for _, l := range assertMaxWaitEvent {
+		class, limit, err := pgcheetah.ParseWaitEventLimit(l)
+		if err != nil {
+			log.Fatal(err)
+		}
+		assertions.MaxWaitEventShare[class] = limit
+	}
 	if *queryFile == "" {
 		log.Println("Provide queryfile with -queryfile")
 		os.Exit(1)
 	}
+	setTargetTPS(*tps)
+
+	manifest := pgcheetah.Manifest{Version: pgcheetah.Version, GoVersion: runtime.Version(), Flags: pgcheetah.FlagValues(flag.CommandLine),
+		QueryFile: *queryFile, NumCPU: runtime.NumCPU()}
+	// Seed is recorded in the manifest, random sources of clients derive from it.
+	// The seed option keeps its value so random seeds are not reported as an option change.
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	manifest.Seed = *seed
+	manifest.Hostname, _ = os.Hostname()
+	if *samplingRate <= 0 || *samplingRate > 1 {
+		log.Fatal("samplingrate must be between 0 and 1")
+	}
+	if *samplingRate != 1 && *aggregateInterval != 0 {
+		log.Fatal("samplingrate and aggregateinterval can not be used together")
+	}
+	if *tuiMode && *output == "ndjson" && *outputFile == "-" {
+		log.Fatal("tui and ndjson output on stdout can not be used together")
+	}
 
+	// Explicit muxes, so pprof is only served with -netpprof
 	if *netpprof {
+		pprofMux := http.NewServeMux()
+		pprofMux.HandleFunc("/debug/pprof/", pprof.Index)
+		pprofMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		pprofMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		pprofMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		pprofMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		// Prometheus metrics can share pprof http server
+		if *promAddr == "localhost:6060" {
+			pprofMux.HandleFunc("/metrics", metricsHandler)
+		}
 		go func() {
 			log.Println("Start pprof http server on http://localhost:6060/debug/pprof/")
-			log.Println(http.ListenAndServe("localhost:6060", nil))
+			log.Println(http.ListenAndServe("localhost:6060", pprofMux))
+		}()
+	}
+	if *promAddr != "" && !(*netpprof && *promAddr == "localhost:6060") {
+		promMux := http.NewServeMux()
+		promMux.HandleFunc("/metrics", metricsHandler)
+		go func() {
+			log.Printf("Start Prometheus metrics http server on http://%s/metrics\n", *promAddr)
+			log.Println(http.ListenAndServe(*promAddr, promMux))
 		}()
 	}
 	think.Min = *thinkTimeMin
@@ -74,80 +209,388 @@ func main() {
 	// Convert delayXact from ms to µs
 	delayXactUs = int(*delayXact * 1000)
 
-	// Initiate timer, will be reseted later
-	timer = time.NewTimer(time.Duration(*duration) * time.Second)
-	timer.Stop()
+	// Without phases in scenario, the test is an optional warmup followed by duration
+	if len(phases) > 0 {
+		if *duration != 0 || *warmup != 0 {
+			log.Fatal("duration and warmup can not be used with scenario phases")
+		}
+	} else {
+		if *warmup > 0 {
+			phases = append(phases, pgcheetah.Phase{Name: "warmup", Duration: *warmup, Warmup: true})
+		}
+		phases = append(phases, pgcheetah.Phase{Name: "steady", Duration: *duration})
+	}
+	if phases[0].Clients == nil && len(groupConfigs) == 0 && *selection != "session" {
+		phases[0].Clients = clients
+	}
+	if phases[0].Clients != nil {
+		*clients = *phases[0].Clients
+	}
+	// Total duration, 0 when last phase runs until interrupted
+	*duration = 0
+	for _, p := range phases {
+		*duration += p.Duration
+	}
+	if phases[len(phases)-1].Duration == 0 {
+		*duration = 0
+	}
 
-	// capture ctrl+c or end of timer to stop workers and display wait_event counters
+	// capture ctrl+c or end of last phase to stop workers and display wait_event counters
 	c := make(chan os.Signal, 1)
+	finished := make(chan bool)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		select {
 		case <-c:
 			log.Print("Stop requested, stop clients\n")
-			for i := 0; i < (*clients + 1); i++ {
-				done <- true
-			}
-			log.Print("Wait_event count:\n")
-			for w, c := range waitEvent {
-				fmt.Printf("%s - %d\n", w, c)
-			}
-		case <-timer.C:
-
+		case <-finished:
 			log.Print("Test finished, stop clients\n")
-			for i := 0; i < (*clients + 1); i++ {
-				done <- true
-			}
-			log.Print("Wait_event count:\n")
-			for w, c := range waitEvent {
-				fmt.Printf("%s	- %d\n", w, c)
-			}
 		}
+		// Stop all workers and rate limiter
+		close(done)
 	}()
 
-	// Add waitgroup for all clients
-	wg.Add(*clients)
-
 	log.Println("Start parsing")
-	xact, err := pgcheetah.ParseXact(data, queryFile, &s, debug)
+	parseStart := time.Now()
+	ann := make(pgcheetah.Annotations)
+	xact, err := pgcheetah.ParseXactAnnotations(data, ann, queryFile, &s, debug)
 	if err != nil {
 		log.Fatalf("Error during parsing %s", err)
 	}
+	manifest.ParseDuration = time.Since(parseStart).Seconds()
+	manifest.Transactions = xact
+	for _, stmts := range data {
+		for _, stmt := range stmts {
+			if stmt != "" {
+				manifest.Statements++
+			}
+		}
+	}
+	if manifest.DatasetSHA256, err = pgcheetah.HashFile(*queryFile); err != nil {
+		log.Fatalf("Error during dataset hashing %s", err)
+	}
 	log.Println("Parsing done, start workers. Transactions processed:", xact)
+	if err = addDataset("", data, ann); err != nil {
+		log.Fatalf("Error during parsing %s", err)
+	}
+	for _, p := range phases {
+		if err = parseDataset(p.QueryFile); err != nil {
+			log.Fatalf("Error during parsing of phase %s %s", p.Name, err)
+		}
+	}
+	if len(groupConfigs) > 0 {
+		if err = newClientGroups(groupConfigs); err != nil {
+			log.Fatalf("Error during parsing %s", err)
+		}
+		// Test target tps is split between throttled groups
+		if *tps == 0 {
+			setTargetTPS(groupsTPS)
+		} else if groupsTPS == 0 {
+			log.Fatal("tps requires groups with tps")
+		}
+		*clients = 0
+		for _, g := range groups {
+			*clients += g.clients
+		}
+	}
+	replay := *selection == "sequential" || *selection == "timestamp" || *selection == "session"
+	if replay && len(datasets) > 1 {
+		log.Fatalf("%s selection requires all clients to play the dataset of queryfile", *selection)
+	}
+	// Timestamp and session replays follow the original timeline instead of a target tps
+	if *selection == "timestamp" || *selection == "session" {
+		if getTargetTPS() != 0 {
+			log.Fatalf("tps can not be used with %s selection", *selection)
+		}
+		for _, p := range phases {
+			if p.TPS != nil {
+				log.Fatalf("phase %s can not set tps with %s selection", p.Name, *selection)
+			}
+		}
+		delayXactUs = 0
+	}
+	// Concurrency of session replay comes from overlapping sessions
+	if sessions != nil {
+		if len(groups) > 0 {
+			log.Fatal("client groups can not be used with session selection")
+		}
+		for _, p := range phases {
+			if p.Clients != nil {
+				log.Fatalf("phase %s can not set clients with session selection", p.Name)
+			}
+		}
+		*clients = len(sessions.IDs)
+	}
+
+	var queries []pgcheetah.MetricQuery
+	if *metricsFile != "" {
+		queries, err = pgcheetah.LoadMetricQueries(*metricsFile)
+		if err != nil {
+			log.Fatalf("Error during metrics file loading %s", err)
+		}
+	}
+
+	// Monitor server under load unless monitoring servers are provided
+	if len(monConnStr) == 0 {
+		monConnStr = append(monConnStr, *connStr)
+	}
+	for _, c := range monConnStr {
+		srv, err := newMonitoredServer(c, queries)
+		if err != nil {
+			log.Fatal(err, " Connection params : ", c)
+		}
+		servers = append(servers, srv)
+	}
+
+	if *output != "" {
+		if *outputFile == "" {
+			*outputFile = "pgcheetah"
+			if *output == "ndjson" {
+				*outputFile += ".ndjson"
+			}
+		}
+		if *output == "ndjson" && *outputFile == "-" {
+			report = os.Stderr
+		}
+		results, err = pgcheetah.NewResultWriter(*output, *outputFile)
+		if err != nil {
+			log.Fatalf("Error during output creation %s", err)
+		}
+	}
+	metadata := pgcheetah.Metadata{Time: time.Now(), Args: pgcheetah.MaskPasswords(os.Args[1:]), QueryFile: *queryFile,
+		Transactions: xact, Clients: *clients, TargetTPS: *tps, Duration: *duration}
+	for _, srv := range servers {
+		metadata.Servers = append(metadata.Servers, srv.label)
+	}
+	record(pgcheetah.RecordMetadata, metadata)
+	manifest.Start = metadata.Time
+	if err = readServerSettings(&manifest); err != nil {
+		log.Printf("Error during server settings reading %s", err)
+	}
 
+	// Registered before start, a replay pass can end before the limiter runs
+	wg.Add(1)
 	go rateLimiter()
 
+	worker.ActiveClients = &activeClients
+	if *xactClasses {
+		classes = pgcheetah.NewXactClasses(data)
+		worker.Classes = classes
+	}
 	worker.ConnStr = connStr
 	worker.Dataset = data
 	worker.DatasetFraction = *datasetFraction
 	worker.DelayXactUs = &delayXactUs
 	worker.Done = done
+	worker.Errors = errorStats
 	worker.QueriesCount = &queriesCount
+	worker.QueryLatency = queryLatency
+	if *pgss {
+		statements = pgcheetah.NewStatementIndex(data)
+		worker.Statements = statements
+	}
+	worker.States = clientStates
+	worker.Stop = stopClient
 	worker.Think = &think
 	worker.Wg = &wg
 	worker.XactCount = &xactCount
-
-	for i := 0; i < *clients; i++ {
-		time.Sleep(time.Duration(*delayStart*1000 / *clients) * time.Millisecond)
-		go pgcheetah.WorkerPG(worker)
+	worker.XactLatency = xactLatency
+
+	if len(groups) > 0 {
+		launchGroups()
+		applyPhase(phases[0], false)
+		log.Println("All workers launched")
+	} else if sessions != nil {
+		launchSessions()
+		applyPhase(phases[0], false)
+	} else {
+		applyPhase(phases[0], true)
+		log.Println("All workers launched")
 	}
-	log.Println("All workers launched")
 
 	// Workers had already processed transactions before all worker have been started.
 	// Reset counter in order to have accurate stats at the end of the test.
+	resetStats()
+	start = measureStart
+	for _, srv := range servers {
+		if err = srv.start(); err != nil {
+			log.Fatalf("Error during monitoring start on %s: %s", srv.label, err)
+		}
+	}
+
+	var dashboard *tui
+	if *tuiMode {
+		if dashboard, err = startTUI(c); err != nil {
+			log.Fatalf("Error during tui start %s", err)
+		}
+	}
+
+	runPhases(phases, finished)
+	wg.Wait()
+	if dashboard != nil {
+		dashboard.close()
+	}
+
+	reportErrors()
+	if *xactClasses {
+		reportXactClasses()
+	}
+	if len(groups) > 0 {
+		reportGroups()
+	}
+	for _, srv := range servers {
+		if err = srv.report(); err != nil {
+			log.Fatalf("Error during monitoring report on %s: %s", srv.label, err)
+		}
+	}
+	passed := checkAssertions(assertions)
+	manifest.Time = time.Now()
+	record(pgcheetah.RecordManifest, manifest)
+
+	if results != nil {
+		if err = results.Close(); err != nil {
+			log.Fatalf("Error during output close %s", err)
+		}
+	}
+	if *htmlReport != "" {
+		if err = writeHTMLReport(*htmlReport); err != nil {
+			log.Fatalf("Error during HTML report generation %s", err)
+		}
+		log.Println("HTML report written to", *htmlReport)
+	}
+	if *resultsConnStr != "" {
+		runID, err := pgcheetah.StoreResults(*resultsConnStr, run)
+		if err != nil {
+			log.Fatalf("Error during results storage %s", err)
+		}
+		log.Println("Results stored with run_id", runID)
+	}
+	if !passed {
+		os.Exit(exitAssertion)
+	}
+
+}
+
+// resetStats clears counters and stats collected by workers, measurement
+// starts again.
+func resetStats() {
 	atomic.StoreInt64(&queriesCount, 0)
 	atomic.StoreInt64(&xactCount, 0)
-	start = time.Now()
+	errorStats.Reset()
+	queryLatency.Reset()
+	xactLatency.Reset()
+	measureStart = time.Now()
+	if *pgss {
+		statements.Reset()
+	}
+	if *xactClasses {
+		classes.Reset()
+	}
+	for _, g := range groups {
+		g.counters.Reset()
+	}
+}
+
+// record keeps a record for reports and writes it in results output if enabled.
+func record(typ string, v interface{}) {
+	if err := run.Write(typ, v); err != nil {
+		log.Printf("Error during %s record %s", typ, err)
+	}
+	if results == nil {
+		return
+	}
+	if err := results.Write(typ, v); err != nil {
+		log.Printf("Error during %s record output %s", typ, err)
+	}
+}
 
-	// Start timer
-	if *duration != 0 {
-		timer.Reset(time.Duration(*duration) * time.Second)
+// readServerSettings adds version and non default settings of the server
+// under load to the manifest.
+func readServerSettings(m *pgcheetah.Manifest) error {
+	db, err := pgcheetah.Connect(*connStr)
+	if err != nil {
+		return err
 	}
+	defer db.Close(context.Background())
+	m.ServerVersion, m.Settings, err = pgcheetah.ServerSettings(db)
+	return err
+}
 
-	go pgcheetah.WaitEventCollector(waitEvent, connStr, *weInterval)
+// writeHTMLReport writes the HTML report of the run in path.
+func writeHTMLReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = run.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	wg.Wait()
+// latencyMs converts a latency in ms for reports.
+func latencyMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
 
+// reportErrors displays errors returned to workers by SQLSTATE.
+func reportErrors() {
+	errorStats.Lock()
+	defer errorStats.Unlock()
+	if len(errorStats.Codes) == 0 {
+		return
+	}
+	log.Print("Errors by SQLSTATE:\n")
+	now := time.Now()
+	for code, count := range errorStats.Codes {
+		fmt.Fprintf(report, "%s	- %d	- %s\n", code, count, errorStats.Messages[code])
+		record(pgcheetah.RecordError, pgcheetah.ErrorStat{Time: now, SQLState: code, Count: count, Message: errorStats.Messages[code]})
+	}
+}
+
+// reportXactClasses displays stats of the most expensive transaction
+// classes and records stats of all executed classes.
+func reportXactClasses() {
+	log.Print("Transaction classes:\n")
+	fmt.Fprintf(report, "%-16s %10s %8s %8s %12s %7s %10s %10s %10s %8s  %s\n", "fingerprint", "count", "errors", "err %",
+		"total ms", "share", "mean ms", "p95 ms", "p99 ms", "xacts", "statements")
+	for i, st := range classes.Stats(time.Now()) {
+		record(pgcheetah.RecordXactClass, st)
+		if i >= *xactClassesLimit {
+			continue
+		}
+		text := st.Statements
+		if len(text) > 60 {
+			text = text[:57] + "..."
+		}
+		fmt.Fprintf(report, "%-16s %10d %8d %8.2f %12.1f %6.1f%% %10.3f %10.3f %10.3f %8d  %s\n", st.Fingerprint, st.Count,
+			st.Errors, st.ErrorRate, st.TotalTime, st.Share, st.LatencyMean, st.LatencyP95, st.LatencyP99, st.DatasetXacts,
+			text)
+	}
+}
+
+// throttle returns the delay between transactions in µs and the step used
+// to change it, in order to reach target tps from current tps.
+func throttle(curtps float64, target float64, delayUs int, step int) (int, int) {
+
+	// We change the step if we are above +/- 1% of wanted tps
+	if int64(curtps) > int64(target*(1+0.01)) {
+
+		// step is calculated in order to, the more we have a difference between wanted tps and current tps
+		// bigger the step is. Inversely, the more we are close to desirated tps, smaller is the step.
+		// The empirical formula is:
+		// step = 10 * deltatps ^ slowStartFactor + 10 * slowStartFactor * deltatps
+		// where delta tps is a ratio between wanted tps and current tps.
+
+		step = int(10*math.Pow(curtps/target, *slowStartFactor) + *slowStartFactor*10*curtps/target)
+
+	} else if int64(curtps) < int64(target*(1-0.01)) {
+
+		// We keep the min between calculated step and current delay to avoid negative delay
+		step = -int(math.Min(10*math.Pow(target/curtps, *slowStartFactor)+*slowStartFactor*10*target/curtps, float64(delayUs)))
+	}
+	return delayUs + step, step
 }
 
 // Naive tps limiting/throttle
@@ -157,47 +600,67 @@ func rateLimiter() {
 	var prevXactCount int64
 	var prevQueriesCount int64
 	var curtps float64
+	var prevLatency []int64
+	var maxLag time.Duration
 	step := 10 // 10µs by default
-	wg.Add(1)
 
 	// Loop every 100ms to calculate throttle to reach wanted tps
 	for i := 0; true; i++ {
 
+		// Counters are reset when measurement starts after warmup
+		if xactCount < prevXactCount {
+			prevXactCount, prevQueriesCount, prevLatency = 0, 0, nil
+		}
 		curtps = float64(xactCount-prevXactCount) * 10
+		target := getTargetTPS()
+		lag := replayLag()
+		if lag > maxLag {
+			maxLag = lag
+		}
+		atomic.StoreInt64(&currentTPS, int64(curtps))
 
 		// Reports stats for each inverval
 		if i%(*interval*10) == 0 {
+			// Timestamp replay reports its lag instead of the delay set by rate limiter
+			delay := "Delay: " + (time.Duration(delayXactUs) * time.Microsecond).String()
+			if *selection == "timestamp" || *selection == "session" {
+				delay = "Replay lag: " + lag.Round(time.Millisecond).String()
+			}
 			if *duration == 0 {
-				log.Printf("TPS: %.f QPS: %d Xact: %d Queries: %d Delay: %s Test duration: %.fs\n",
-					curtps, (queriesCount-prevQueriesCount)*10, xactCount, queriesCount,
-					time.Duration(delayXactUs)*time.Microsecond, time.Since(start).Seconds())
+				log.Printf("TPS: %.f QPS: %d Xact: %d Queries: %d %s Test duration: %.fs\n",
+					curtps, (queriesCount-prevQueriesCount)*10, xactCount, queriesCount, delay,
+					time.Since(start).Seconds())
 			} else {
-				log.Printf("TPS: %.f QPS: %d Xact: %d Queries: %d Delay: %s Remaining: %.fs\n",
-					curtps, (queriesCount-prevQueriesCount)*10, xactCount, queriesCount,
-					time.Duration(delayXactUs)*time.Microsecond, float64(*duration)-time.Since(start).Seconds())
+				log.Printf("TPS: %.f QPS: %d Xact: %d Queries: %d %s Remaining: %.fs\n",
+					curtps, (queriesCount-prevQueriesCount)*10, xactCount, queriesCount, delay,
+					float64(*duration)-time.Since(start).Seconds())
 			}
-		}
-		if *tps != 0 {
-
-			// We change the step if we are above +/- 1% of wanted tps
-			if int64(curtps) > int64(*tps*(1+0.01)) {
-
-				// step is calculated in order to, the more we have a difference between wanted tps and current tps
-				// bigger the step is. Inversely, the more we are close to desirated tps, smaller is the step.
-				// The empirical formula is:
-				// step = 10 * deltatps ^ slowStartFactor + 10 * slowStartFactor * deltatps
-				// where delta tps is a ratio between wanted tps and current tps.
-
-				step = int(10*math.Pow(curtps / *tps, *slowStartFactor) + *slowStartFactor*10*curtps / *tps)
-				//log.Printf("> TPS: %d	- tps diff %d	Delay: %d => %d\n", (xactCount-prevXactCount)*10, int64(*tps*(1+0.1)), delayXactUs, delayXactUs+step)
-
-			} else if int64(curtps) < int64(*tps*(1-0.01)) {
-
-				// We keep the min between calculated step and current delayXactUs to avoid negative delayXactUs
-				step = -int(math.Min(10*math.Pow(*tps/curtps, *slowStartFactor)+*slowStartFactor*10**tps/curtps, float64(delayXactUs)))
-				//log.Printf("< TPS: %d	- tps diff %d	Delay: %d => %d\n", (xactCount-prevXactCount)*10, int64(*tps*(1-0.1)), delayXactUs, delayXactUs+step)
+			// Latency percentiles of transactions executed during the interval
+			latency := xactLatency.Counts()
+			intervalLatency := make([]int64, len(latency))
+			for j := range latency {
+				intervalLatency[j] = latency[j]
+				if prevLatency != nil {
+					intervalLatency[j] -= prevLatency[j]
+				}
 			}
-			delayXactUs += step
+			prevLatency = latency
+
+			elapsed := time.Since(start).Seconds()
+			record(pgcheetah.RecordInterval, pgcheetah.IntervalStats{Time: time.Now(), Elapsed: elapsed,
+				TPS: curtps, QPS: float64((queriesCount - prevQueriesCount) * 10), Xact: xactCount, Queries: queriesCount,
+				Errors: errorStats.Count(), DelayUs: delayXactUs, ActiveClients: atomic.LoadInt64(&activeClients),
+				LatencyP50: latencyMs(pgcheetah.PercentileOf(intervalLatency, 50)),
+				LatencyP95: latencyMs(pgcheetah.PercentileOf(intervalLatency, 95)),
+				LatencyP99: latencyMs(pgcheetah.PercentileOf(intervalLatency, 99)), ReplayLag: latencyMs(lag)})
+			for _, srv := range servers {
+				srv.reportInterval(elapsed)
+			}
+		}
+		if len(groups) > 0 {
+			throttleGroups()
+		} else if target != 0 {
+			delayXactUs, step = throttle(curtps, target, delayXactUs, step)
 		}
 		prevXactCount = xactCount
 		prevQueriesCount = queriesCount
@@ -206,9 +669,21 @@ func rateLimiter() {
 		case <-done:
 
 			t := time.Now()
-			elapsed := t.Sub(start)
+			elapsed := t.Sub(measureStart)
 			log.Printf("End test - Clients: %d - Elapsed: %s - Average TPS: %.f - Average QPS: %.f\n",
 				*clients, elapsed.String(), float64(xactCount)/elapsed.Seconds(), float64(queriesCount)/elapsed.Seconds())
+			if *selection == "timestamp" || *selection == "session" {
+				log.Printf("Max replay lag: %s\n", maxLag.Round(time.Millisecond))
+			}
+			record(pgcheetah.RecordSummary, pgcheetah.Summary{Time: t, Start: measureStart, Clients: *clients, Elapsed: elapsed.Seconds(),
+				Xact: xactCount, Queries: queriesCount, Errors: errorStats.Count(),
+				TPS: float64(xactCount) / elapsed.Seconds(), QPS: float64(queriesCount) / elapsed.Seconds(),
+				LatencyMean: latencyMs(xactLatency.Mean()), LatencyP50: latencyMs(xactLatency.Percentile(50)),
+				LatencyP95: latencyMs(xactLatency.Percentile(95)), LatencyP99: latencyMs(xactLatency.Percentile(99)),
+				MaxReplayLag: latencyMs(maxLag)})
+			for _, b := range xactLatency.Buckets() {
+				record(pgcheetah.RecordLatencyBucket, b)
+			}
 			wg.Done()
 			return
 		default:
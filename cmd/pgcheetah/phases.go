@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"log"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Datasets, their transaction selectors and templated statements by query
+// file, "" is the dataset of queryfile option
+var (
+	datasets  = make(map[string]map[int][]string)
+	selectors = make(map[string]*pgcheetah.Selector)
+	templates = make(map[string]pgcheetah.Templates)
+)
+
+// Original sessions of queryfile dataset with session selection, sessionsDone
+// is closed when all sessions are done
+var (
+	sessions     *pgcheetah.Sessions
+	sessionsDone chan bool
+)
+
+// Clients launched by phases, only changed by main goroutine
+var (
+	nextClientID     int
+	runningClients   int
+	runningQueryFile string
+	stopClient       = make(chan bool)
+)
+
+// applyPhase sets target tps and number of clients of a phase. Clients are
+// replaced when the phase has its own dataset. When spread is set, clients
+// start is spread among delaystart seconds.
+func applyPhase(p pgcheetah.Phase, spread bool) {
+
+	if p.TPS != nil {
+		setTargetTPS(*p.TPS)
+	}
+	target := runningClients
+	if p.Clients != nil {
+		target = *p.Clients
+	}
+	if p.QueryFile != runningQueryFile {
+		stopClients(runningClients)
+		runningQueryFile = p.QueryFile
+	}
+	if target > runningClients {
+		launchClients(target-runningClients, spread)
+	} else if target < runningClients {
+		stopClients(runningClients - target)
+	}
+}
+
+// parseDataset parses a query file in datasets, unless it is already
+// parsed. "" is the dataset of queryfile option.
+func parseDataset(queryFile string) error {
+
+	if datasets[queryFile] != nil {
+		return nil
+	}
+	data := map[int][]string{0: {""}}
+	ann := make(pgcheetah.Annotations)
+	s := pgcheetah.State{Statedesc: "init", Xact: 0, XactInProgress: false}
+	xact, err := pgcheetah.ParseXactAnnotations(data, ann, &queryFile, &s, debug)
+	if err != nil {
+		return err
+	}
+	log.Printf("Dataset %s parsed. Transactions processed: %d\n", queryFile, xact)
+	return addDataset(queryFile, data, ann)
+}
+
+// addDataset adds a parsed dataset with its transaction selector.
+func addDataset(queryFile string, data map[int][]string, ann pgcheetah.Annotations) error {
+
+	if *speed <= 0 {
+		return fmt.Errorf("speed must be positive")
+	}
+	t, err := pgcheetah.ParseTemplates(data, ann, filepath.Dir(datasetPath(queryFile)))
+	if err != nil {
+		return err
+	}
+	templates[queryFile] = t
+	if *selection == "session" {
+		s, err := pgcheetah.NewSessions(data, ann, *datasetFraction)
+		if err != nil {
+			return err
+		}
+		s.SetSpeed(*speed)
+		datasets[queryFile] = data
+		sessions = s
+		return nil
+	}
+	selector, err := pgcheetah.NewSelector(*selection, data, ann, *datasetFraction, *zipfExponent)
+	if err != nil {
+		return err
+	}
+	selector.Speed = *speed
+	datasets[queryFile] = data
+	selectors[queryFile] = selector
+	return nil
+}
+
+// datasetPath returns the path of a dataset, "" is the dataset of queryfile
+// option.
+func datasetPath(file string) string {
+	if file == "" {
+		return *queryFile
+	}
+	return file
+}
+
+// datasetWorker returns the worker template playing a dataset.
+func datasetWorker(queryFile string) pgcheetah.Worker {
+	w := worker
+	w.Dataset = datasets[queryFile]
+	w.Selector = selectors[queryFile]
+	w.Templates = templates[queryFile]
+	if sessions != nil {
+		// Clients of sessions are started by launchSessions
+		w.Selector = nil
+	}
+	if queryFile != "" {
+		// Transaction classes and statements are indexed on queryfile dataset
+		w.Classes = nil
+		w.Statements = nil
+	}
+	return w
+}
+
+// launchClients starts n workers on the dataset of running phase.
+func launchClients(n int, spread bool) {
+	var pause time.Duration
+	if spread {
+		pause = time.Duration(*delayStart*1000/n) * time.Millisecond
+	}
+	startWorkers(datasetWorker(runningQueryFile), n, pause)
+}
+
+// startWorkers starts n workers from template w, waiting pause before each.
+func startWorkers(w pgcheetah.Worker, n int, pause time.Duration) {
+	for i := 0; i < n; i++ {
+		time.Sleep(pause)
+		startWorker(w, nextClientID)
+		nextClientID++
+		runningClients++
+	}
+}
+
+// startWorker starts a worker from template w with client id.
+func startWorker(w pgcheetah.Worker, id int) {
+
+	var err error
+	w.ID = id
+	w.Seed = pgcheetah.ClientSeed(*seed, w.ID)
+	if *xactLogPrefix != "" {
+		w.XactLog, err = pgcheetah.NewXactLog(*xactLogPrefix, w.ID, *samplingRate, time.Duration(*aggregateInterval)*time.Second)
+		if err != nil {
+			log.Fatalf("Error during transaction log creation %s", err)
+		}
+	}
+	wg.Add(1)
+	go pgcheetah.WorkerPG(w)
+}
+
+// launchSessions starts replay of original sessions. Each session connects
+// at its original start and disconnects after its last transaction, client
+// id is the session index.
+func launchSessions() {
+
+	log.Printf("Start replay of %d sessions\n", len(sessions.IDs))
+	sessionsDone = make(chan bool)
+	sessions.Start(time.Now())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, sel := range sessions.Selectors {
+			select {
+			case <-time.After(time.Until(sessions.SessionStart(i))):
+			case <-done:
+				return
+			}
+			w := datasetWorker("")
+			w.Selector = sel
+			startWorker(w, i)
+		}
+	}()
+	go func() {
+		for _, sel := range sessions.Selectors {
+			select {
+			case <-sel.Passed():
+			case <-done:
+				return
+			}
+		}
+		close(sessionsDone)
+	}()
+}
+
+// replayDone returns a channel closed at the end of sequential, timestamp or
+// session replay, nil with other selections.
+func replayDone() <-chan bool {
+	if sessions != nil {
+		return sessionsDone
+	}
+	return selectors[""].Passed()
+}
+
+// replayLag returns how far timestamp or session replay is behind the
+// original timeline.
+func replayLag() time.Duration {
+	if sessions != nil {
+		return sessions.Lag()
+	}
+	return selectors[""].Lag()
+}
+
+// stopClients stops n workers, each worker stops after its current query.
+func stopClients(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case stopClient <- true:
+			runningClients--
+		case <-done:
+			return
+		}
+	}
+}
+
+// phaseClients returns the number of clients of the running phase, connected
+// sessions with session selection.
+func phaseClients() int {
+	if sessions != nil {
+		return int(atomic.LoadInt64(&activeClients))
+	}
+	return runningClients
+}
+
+// phaseCounters reads counters used to compute phase stats.
+func phaseCounters() pgcheetah.PhaseCounters {
+	return pgcheetah.PhaseCounters{Time: time.Now(), Xact: atomic.LoadInt64(&xactCount),
+		Queries: atomic.LoadInt64(&queriesCount), Errors: errorStats.Count(), Latency: xactLatency.Counts(),
+		LatencySum: xactLatency.Sum()}
+}
+
+// runPhases runs phases in order, the first phase is already applied.
+// finished is closed at the end of the last phase or of the sequential
+// pass. Stats of each phase are reported when there are several phases,
+// measurement starts again at the end of warmup phases.
+func runPhases(phases []pgcheetah.Phase, finished chan bool) {
+
+	for i, p := range phases {
+		if i > 0 {
+			applyPhase(p, false)
+		}
+		if len(phases) > 1 {
+			log.Printf("Phase %s - Clients: %d - Target TPS: %.f - Duration: %ds\n", p.Name, phaseClients(), getTargetTPS(), p.Duration)
+		}
+		counters := phaseCounters()
+		waitEvents := make([]map[string]int, len(servers))
+		for j, srv := range servers {
+			waitEvents[j] = srv.waitEvent.Snapshot()
+		}
+
+		var timeout <-chan time.Time
+		if p.Duration > 0 {
+			timeout = time.After(time.Duration(p.Duration) * time.Second)
+		}
+		stopped, passed := false, false
+		select {
+		case <-timeout:
+		case <-done:
+			stopped = true
+		case <-replayDone():
+			log.Println("Replay done")
+			passed = true
+		}
+
+		if len(phases) > 1 {
+			recordPhase(p, counters, waitEvents)
+		}
+		if stopped {
+			return
+		}
+		if passed {
+			break
+		}
+		if p.Warmup && !phases[i+1].Warmup {
+			log.Println("Warmup done, start measurement")
+			resetStats()
+			for _, srv := range servers {
+				if err := srv.reset(); err != nil {
+					log.Fatalf("Error during monitoring reset on %s: %s", srv.label, err)
+				}
+			}
+		}
+	}
+	close(finished)
+}
+
+// recordPhase reports stats and wait events of a phase since its start.
+func recordPhase(p pgcheetah.Phase, counters pgcheetah.PhaseCounters, waitEvents []map[string]int) {
+
+	st := pgcheetah.NewPhaseStats(p, counters, phaseCounters())
+	st.Clients = phaseClients()
+	st.TargetTPS = getTargetTPS()
+	log.Printf("End phase %s - Elapsed: %.fs - TPS: %.f - QPS: %.f - Errors: %d - Latency p50/p95/p99: %.3f/%.3f/%.3f ms\n",
+		p.Name, st.Elapsed, st.TPS, st.QPS, st.Errors, st.LatencyP50, st.LatencyP95, st.LatencyP99)
+	record(pgcheetah.RecordPhase, st)
+	for i, srv := range servers {
+		for w, c := range srv.waitEvent.Snapshot() {
+			if c > waitEvents[i][w] {
+				record(pgcheetah.RecordPhaseWaitEvent, pgcheetah.WaitEventStat{Time: st.Time,
+					Elapsed: st.Time.Sub(start).Seconds(), Server: srv.label, Event: w, Count: c - waitEvents[i][w],
+					Phase: p.Name})
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// metricsHandler serves pgcheetah metrics in Prometheus text exposition format.
+// Counters are reset when all workers have been launched.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	pgcheetah.WritePromMetric(w, "pgcheetah_transactions_total", "Number of transactions executed", "counter",
+		pgcheetah.PromSample{Value: float64(atomic.LoadInt64(&xactCount))})
+	pgcheetah.WritePromMetric(w, "pgcheetah_queries_total", "Number of queries executed", "counter",
+		pgcheetah.PromSample{Value: float64(atomic.LoadInt64(&queriesCount))})
+	pgcheetah.WritePromHistogram(w, "pgcheetah_transaction_latency_seconds", "Transaction latency without think time", xactLatency)
+	pgcheetah.WritePromHistogram(w, "pgcheetah_query_latency_seconds", "Query latency", queryLatency)
+	pgcheetah.WritePromMetric(w, "pgcheetah_delay_xact_seconds", "Delay between each transaction", "gauge",
+		pgcheetah.PromSample{Value: (time.Duration(delayXactUs) * time.Microsecond).Seconds()})
+	pgcheetah.WritePromMetric(w, "pgcheetah_target_tps", "Expected transactions per second, 0 when not limited", "gauge",
+		pgcheetah.PromSample{Value: getTargetTPS()})
+	pgcheetah.WritePromMetric(w, "pgcheetah_tps", "Transactions per second measured by rate limiter", "gauge",
+		pgcheetah.PromSample{Value: float64(atomic.LoadInt64(&currentTPS))})
+	pgcheetah.WritePromMetric(w, "pgcheetah_active_clients", "Number of connected clients", "gauge",
+		pgcheetah.PromSample{Value: float64(atomic.LoadInt64(&activeClients))})
+
+	var samples []pgcheetah.PromSample
+	errorStats.Lock()
+	for code, count := range errorStats.Codes {
+		samples = append(samples, pgcheetah.PromSample{Labels: map[string]string{"sqlstate": code}, Value: float64(count)})
+	}
+	errorStats.Unlock()
+	pgcheetah.WritePromMetric(w, "pgcheetah_errors_total", "Number of errors by SQLSTATE", "counter", samples...)
+
+	samples = nil
+	for _, srv := range servers {
+		for event, count := range srv.waitEvent.Snapshot() {
+			samples = append(samples, pgcheetah.PromSample{Labels: map[string]string{"server": srv.label, "event": event}, Value: float64(count)})
+		}
+	}
+	pgcheetah.WritePromMetric(w, "pgcheetah_wait_event_samples_total", "Number of sessions sampled in a wait event", "counter", samples...)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+)
+
+// applyScenario sets options from a scenario file and returns it.
+// Options given on the command line take precedence over the scenario.
+func applyScenario(path string) (*pgcheetah.Scenario, error) {
+
+	scenario, err := pgcheetah.LoadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, values := range scenario.Flags() {
+		if set[name] {
+			continue
+		}
+		for _, v := range values {
+			if err = flag.Set(name, v); err != nil {
+				return nil, fmt.Errorf("scenario %s: %s", name, err)
+			}
+		}
+	}
+	return scenario, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// synthesize implements "pgcheetah synthesize". It builds a dataset from a
+// pg_stat_statements snapshot: each query is written with several variants
+// of parameters sampled from the target database, weighted by its calls.
+// It returns the exit code.
+func synthesize(args []string) int {
+
+	fs := flag.NewFlagSet("synthesize", flag.ExitOnError)
+	connStr := fs.String("constr", "user=postgres dbname=postgres", "pg connstring of the database where parameter values are sampled")
+	csvFile := fs.String("csv", "", "pg_stat_statements snapshot saved as CSV with a header")
+	table := fs.String("table", "", "Table holding a pg_stat_statements snapshot")
+	snapshotConnStr := fs.String("snapshotconstr", "", "pg connstring of the database holding the snapshot table (default constr)")
+	output := fs.String("output", "-", "Dataset file, - for stdout")
+	variants := fs.Int("variants", 100, "Statements generated for each query, with different parameters")
+	seed := fs.Int64("seed", 0, "Seed of parameters sampling, 0 picks a random seed")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s synthesize [options] -csv pgss.csv | -table snapshot\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if (*csvFile == "") == (*table == "") || fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	if *variants <= 0 {
+		log.Fatal("Error: variants must be positive")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	db, err := pgcheetah.Connect(*connStr)
+	if err != nil {
+		log.Fatalf("Error during connection %s", err)
+	}
+	defer db.Close(context.Background())
+
+	var stmts []pgcheetah.StatementStats
+	if *csvFile != "" {
+		stmts, err = pgcheetah.LoadStatementsCSV(*csvFile)
+	} else {
+		snapshotDB := db
+		if *snapshotConnStr != "" {
+			if snapshotDB, err = pgcheetah.Connect(*snapshotConnStr); err != nil {
+				log.Fatalf("Error during connection %s", err)
+			}
+			defer snapshotDB.Close(context.Background())
+		}
+		stmts, err = pgcheetah.LoadStatementsTable(snapshotDB, *table)
+	}
+	if err != nil {
+		log.Fatalf("Error during snapshot loading %s", err)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatalf("Error during dataset creation %s", err)
+		}
+		defer out.Close()
+	}
+	buf := bufio.NewWriter(out)
+
+	syn := pgcheetah.NewSynthesizer(db)
+	r := rand.New(rand.NewSource(*seed))
+	written, skipped := 0, 0
+	for _, st := range stmts {
+		queries, err := syn.Statements(st.Query, *variants, r)
+		if err != nil {
+			log.Printf("Skip query %.80q: %s\n", st.Query, err)
+			skipped++
+			continue
+		}
+		// Each variant weighs its share of the calls, replay with weighted selection
+		weight := strconv.FormatFloat(float64(st.Calls)/float64(*variants), 'f', -1, 64)
+		for _, q := range queries {
+			fmt.Fprintf(buf, "-- pgcheetah: weight=%s\n%s\n", weight, q)
+		}
+		written++
+	}
+	if err = buf.Flush(); err != nil {
+		log.Fatalf("Error during dataset writing %s", err)
+	}
+	log.Printf("Dataset synthesized from %d queries, %d skipped, seed %d\n", written, skipped, *seed)
+	return 0
+}
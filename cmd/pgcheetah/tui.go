@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/anayrat/pgcheetah/v2/pkg/pgcheetah"
+	"golang.org/x/crypto/ssh/terminal"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of log lines kept by the dashboard
+const tuiLogLines = 1000
+
+// tui is a full screen dashboard refreshed during the test. It displays
+// stats recorded by rateLimiter and monitored servers, log lines are
+// captured and displayed at the bottom of the screen. They are also
+// written on stderr when it is redirected.
+type tui struct {
+	sync.Mutex
+	fd       int
+	oldState *terminal.State
+	logLines []string
+	partial  []byte
+	tee      bool           // Write log lines on stderr too
+	stop     chan os.Signal // Receives a signal when q is hit
+	quit     chan bool
+	done     sync.WaitGroup
+}
+
+// startTUI switches the terminal in raw mode and starts refreshing the
+// dashboard. stop is the channel used to stop the test.
+func startTUI(stop chan os.Signal) (*tui, error) {
+
+	fd := int(os.Stdout.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("stdout is not a terminal")
+	}
+	oldState, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	t := &tui{fd: fd, oldState: oldState, stop: stop, quit: make(chan bool), tee: !terminal.IsTerminal(int(os.Stderr.Fd()))}
+	log.SetOutput(t)
+	// Hide cursor
+	fmt.Print("\x1b[?25l")
+
+	go t.readKeys()
+	t.done.Add(1)
+	go func() {
+		defer t.done.Done()
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			t.draw()
+			select {
+			case <-t.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return t, nil
+}
+
+// Write captures log lines, it implements io.Writer for log.
+func (t *tui) Write(p []byte) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.tee {
+		os.Stderr.Write(p)
+	}
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.logLines = append(t.logLines, string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.logLines) > tuiLogLines {
+		t.logLines = t.logLines[len(t.logLines)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+// readKeys handles keybindings: + and - change target tps by 10%,
+// q or ctrl-c stop the test.
+func (t *tui) readKeys() {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+			return
+		}
+		switch buf[0] {
+		case '+', '=':
+			target := getTargetTPS()
+			if target == 0 {
+				// No limit yet, start from current tps
+				target = float64(atomic.LoadInt64(&currentTPS))
+			}
+			setTargetTPS(target * 1.1)
+			log.Printf("Target TPS changed to %.f\n", getTargetTPS())
+		case '-':
+			target := getTargetTPS()
+			if target == 0 {
+				target = float64(atomic.LoadInt64(&currentTPS))
+			}
+			if target*0.9 >= 1 {
+				setTargetTPS(target * 0.9)
+			}
+			log.Printf("Target TPS changed to %.f\n", getTargetTPS())
+		case 'q', 'Q', 3:
+			// Test may be already stopping
+			select {
+			case t.stop <- os.Interrupt:
+			default:
+			}
+			return
+		}
+	}
+}
+
+// draw refreshes the screen.
+func (t *tui) draw() {
+
+	width, height, err := terminal.GetSize(t.fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	sparkWidth := width - 24
+	if sparkWidth < 10 {
+		sparkWidth = 10
+	}
+
+	var tpsSeries, p99Series, delaySeries []float64
+	var last pgcheetah.IntervalStats
+	var waitEvents []pgcheetah.WaitEventStat
+	run.Lock()
+	for _, i := range run.Intervals {
+		tpsSeries = append(tpsSeries, i.TPS)
+		p99Series = append(p99Series, i.LatencyP99)
+		delaySeries = append(delaySeries, float64(i.DelayUs)/1000)
+	}
+	if len(run.Intervals) > 0 {
+		last = run.Intervals[len(run.Intervals)-1]
+	}
+	// Wait events sampled during the last interval
+	for i := len(run.IntervalWaitEvents) - 1; i >= 0 && run.IntervalWaitEvents[i].Elapsed == last.Elapsed; i-- {
+		waitEvents = append(waitEvents, run.IntervalWaitEvents[i])
+	}
+	run.Unlock()
+	sort.Slice(waitEvents, func(i, j int) bool { return waitEvents[i].Count > waitEvents[j].Count })
+
+	var lines []string
+	status := fmt.Sprintf("pgcheetah - elapsed %.fs", last.Elapsed)
+	if *duration != 0 {
+		status += fmt.Sprintf(" - remaining %.fs", float64(*duration)-last.Elapsed)
+	}
+	target := "none"
+	if v := getTargetTPS(); v != 0 {
+		target = fmt.Sprintf("%.f", v)
+	}
+	lines = append(lines, status+" - target TPS "+target+"    [+/-] change target  [q] stop", "")
+	lines = append(lines, fmt.Sprintf("%-10s %10.f  %s", "TPS", last.TPS, pgcheetah.Sparkline(tpsSeries, sparkWidth)))
+	lines = append(lines, fmt.Sprintf("%-10s %10.3f  %s", "p99 ms", last.LatencyP99, pgcheetah.Sparkline(p99Series, sparkWidth)))
+	lines = append(lines, fmt.Sprintf("%-10s %10.3f  %s", "delay ms", float64(last.DelayUs)/1000, pgcheetah.Sparkline(delaySeries, sparkWidth)))
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("Clients: %d connected - %d running - %d thinking - %d sleeping",
+		atomic.LoadInt64(&activeClients), clientStates.Count(pgcheetah.ClientRunning),
+		clientStates.Count(pgcheetah.ClientThinking), clientStates.Count(pgcheetah.ClientSleeping)))
+	lines = append(lines, fmt.Sprintf("Xact: %d - Queries: %d - Errors: %d%s", last.Xact, last.Queries, errorStats.Count(), topErrors(3)))
+	lines = append(lines, "", "Top wait events (last interval):")
+	for i, w := range waitEvents {
+		if i >= 5 {
+			break
+		}
+		event := w.Event
+		if len(servers) > 1 {
+			event = w.Server + " " + event
+		}
+		lines = append(lines, fmt.Sprintf("  %-50s %8d", event, w.Count))
+	}
+
+	// Last log lines fill the rest of the screen
+	lines = append(lines, "", "Log:")
+	t.Lock()
+	n := height - len(lines) - 1
+	if n < 0 {
+		n = 0
+	}
+	logLines := t.logLines
+	if len(logLines) > n {
+		logLines = logLines[len(logLines)-n:]
+	}
+	lines = append(lines, logLines...)
+	t.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	for _, l := range lines {
+		if len([]rune(l)) > width {
+			l = string([]rune(l)[:width])
+		}
+		b.WriteString(l + "\r\n")
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+// close stops refreshing, restores the terminal and writes captured log
+// lines on stderr.
+func (t *tui) close() {
+	close(t.quit)
+	t.done.Wait()
+	fmt.Print("\x1b[H\x1b[2J\x1b[?25h")
+	terminal.Restore(t.fd, t.oldState)
+	log.SetOutput(os.Stderr)
+	t.Lock()
+	defer t.Unlock()
+	if t.tee {
+		return
+	}
+	for _, l := range t.logLines {
+		fmt.Fprintln(os.Stderr, l)
+	}
+}
+
+// topErrors returns the most frequent SQLSTATE with their count.
+func topErrors(n int) string {
+	errorStats.Lock()
+	codes := make(map[string]int, len(errorStats.Codes))
+	for c, count := range errorStats.Codes {
+		codes[c] = int(count)
+	}
+	errorStats.Unlock()
+	var s []string
+	for _, c := range pgcheetah.Top(codes, n) {
+		s = append(s, fmt.Sprintf("%s: %d", c, codes[c]))
+	}
+	if len(s) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(s, ", ") + ")"
+}
@@ -3,9 +3,10 @@ module github.com/anayrat/pgcheetah/v2
 go 1.13
 
 require (
-	github.com/anayrat/pgcheetah v0.0.0-20191012150349-1c4bd011bd1e
+	github.com/jackc/pgconn v1.1.0
 	github.com/jackc/pgtype v1.0.3 // indirect
 	github.com/jackc/pgx/v4 v4.1.2
-	golang.org/x/crypto v0.0.0-20191219195013-becbf705a915 // indirect
+	golang.org/x/crypto v0.0.0-20191219195013-becbf705a915
 	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,103 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assertions are thresholds checked at the end of a run. Nil thresholds are
+// not checked, a zero threshold is, like no error allowed. MaxWaitEventShare
+// is the maximum share in percent of a wait event class, like Lock or IO,
+// among wait events sampled on each server.
+type Assertions struct {
+	MinTPS            *float64
+	MaxP99            *float64 // Transaction latency in ms
+	MaxErrorRate      *float64 // Failed queries in percent
+	MaxWaitEventShare map[string]float64
+}
+
+// AssertionResult is the outcome of an assertion.
+type AssertionResult struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	Limit  float64   `json:"limit"`
+	Value  float64   `json:"value"`
+	Passed bool      `json:"passed"`
+}
+
+// ParseWaitEventLimit parses a wait event class limit like "Lock=5".
+func ParseWaitEventLimit(s string) (string, float64, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 {
+		return "", 0, fmt.Errorf("wait event limit %s must be class=percent", s)
+	}
+	limit, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil || limit < 0 {
+		return "", 0, fmt.Errorf("wait event limit %s must be a positive percent", s)
+	}
+	return s[:i], limit, nil
+}
+
+// waitEventClassShares returns the highest share of each wait event class
+// among monitored servers, in percent.
+func waitEventClassShares(r *Results) map[string]float64 {
+	totals := make(map[string]int)
+	counts := make(map[string]map[string]int)
+	for _, w := range r.WaitEvents {
+		class := strings.SplitN(w.Event, "-", 2)[0]
+		totals[w.Server] += w.Count
+		if counts[w.Server] == nil {
+			counts[w.Server] = make(map[string]int)
+		}
+		counts[w.Server][class] += w.Count
+	}
+	shares := make(map[string]float64)
+	for server, classes := range counts {
+		for class, c := range classes {
+			if share := 100 * float64(c) / float64(totals[server]); share > shares[class] {
+				shares[class] = share
+			}
+		}
+	}
+	return shares
+}
+
+// Check evaluates assertions against the summary and wait events of a run.
+func Check(r *Results, a Assertions) []AssertionResult {
+
+	var results []AssertionResult
+	now := time.Now()
+	if a.MinTPS != nil {
+		results = append(results, AssertionResult{Time: now, Name: "average TPS >=", Limit: *a.MinTPS,
+			Value: r.Summary.TPS, Passed: r.Summary.TPS >= *a.MinTPS})
+	}
+	if a.MaxP99 != nil {
+		results = append(results, AssertionResult{Time: now, Name: "p99 latency (ms) <=", Limit: *a.MaxP99,
+			Value: r.Summary.LatencyP99, Passed: r.Summary.LatencyP99 <= *a.MaxP99})
+	}
+	if a.MaxErrorRate != nil {
+		var rate float64
+		if r.Summary.Queries > 0 {
+			rate = 100 * float64(r.Summary.Errors) / float64(r.Summary.Queries)
+		}
+		results = append(results, AssertionResult{Time: now, Name: "error rate (%) <=", Limit: *a.MaxErrorRate,
+			Value: rate, Passed: rate <= *a.MaxErrorRate})
+	}
+	if len(a.MaxWaitEventShare) > 0 {
+		shares := waitEventClassShares(r)
+		var classes []string
+		for class := range a.MaxWaitEventShare {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			limit := a.MaxWaitEventShare[class]
+			results = append(results, AssertionResult{Time: now, Name: "wait event " + class + " share (%) <=",
+				Limit: limit, Value: shares[class], Passed: shares[class] <= limit})
+		}
+	}
+	return results
+}
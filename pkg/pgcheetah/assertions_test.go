@@ -0,0 +1,83 @@
+package pgcheetah
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+
+	r := &Results{Summary: Summary{TPS: 950, Queries: 10000, Errors: 50, LatencyP99: 8}}
+	r.WaitEvents = []WaitEventStat{
+		{Server: "primary", Event: "Lock-tuple", Count: 10},
+		{Server: "primary", Event: "Lock-transactionid", Count: 10},
+		{Server: "primary", Event: "IO-DataFileRead", Count: 80},
+		{Server: "replica", Event: "IO-DataFileRead", Count: 10},
+	}
+	minTPS, maxP99, maxErrorRate := 1000.0, 10.0, 1.0
+	a := Assertions{MinTPS: &minTPS, MaxP99: &maxP99, MaxErrorRate: &maxErrorRate,
+		MaxWaitEventShare: map[string]float64{"Lock": 15, "IO": 100}}
+
+	var tests = []struct {
+		name   string
+		value  float64
+		passed bool
+	}{
+		{"average TPS >=", 950, false},
+		{"p99 latency (ms) <=", 8, true},
+		{"error rate (%) <=", 0.5, true},
+		{"wait event IO share (%) <=", 100, true},
+		{"wait event Lock share (%) <=", 20, false},
+	}
+
+	results := Check(r, a)
+	if len(results) != len(tests) {
+		t.Fatal("Expected ", len(tests), " results, got ", len(results))
+	}
+	for i, test := range tests {
+		if results[i].Name != test.name || math.Abs(results[i].Value-test.value) > 0.01 || results[i].Passed != test.passed {
+			t.Error("Test TestCheck #", i, "Expected ", test, " got ", results[i])
+		}
+	}
+
+	if results = Check(r, Assertions{}); len(results) != 0 {
+		t.Error("Expected no assertion, got ", results)
+	}
+
+	// A zero limit is checked, no error allowed
+	zero := 0.0
+	results = Check(r, Assertions{MaxErrorRate: &zero})
+	if len(results) != 1 || results[0].Passed {
+		t.Error("Expected a failed error rate assertion, got ", results)
+	}
+	r.Summary.Errors = 0
+	if results = Check(r, Assertions{MaxErrorRate: &zero}); len(results) != 1 || !results[0].Passed {
+		t.Error("Expected a passed error rate assertion, got ", results)
+	}
+}
+
+func TestParseWaitEventLimit(t *testing.T) {
+
+	var tests = []struct {
+		in    string
+		class string
+		limit float64
+		err   bool
+	}{
+		{"Lock=5", "Lock", 5, false},
+		{"LWLock=12.5", "LWLock", 12.5, false},
+		{"Lock", "", 0, true},
+		{"=5", "", 0, true},
+		{"Lock=x", "", 0, true},
+		{"Lock=5abc", "", 0, true},
+		{"Lock=-1", "", 0, true},
+		{"Lock=0", "Lock", 0, false},
+	}
+
+	for i, test := range tests {
+		class, limit, err := ParseWaitEventLimit(test.in)
+		if (err != nil) != test.err || class != test.class || limit != test.limit {
+			t.Error("Test TestParseWaitEventLimit #", i, "Expected ", test.class, test.limit, test.err, " got ", class, limit, err)
+		}
+	}
+}
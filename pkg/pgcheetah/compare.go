@@ -0,0 +1,171 @@
+package pgcheetah
+
+import (
+	"math"
+	"sort"
+)
+
+// Thresholds are the changes beyond which a comparison is a regression.
+// TPS and Latency are relative changes in percent, ErrorRate and WaitEvent
+// are absolute changes in percentage points.
+type Thresholds struct {
+	TPS       float64
+	Latency   float64
+	ErrorRate float64
+	WaitEvent float64
+}
+
+// Units of a Comparison change
+const (
+	UnitPercent = "%"  // Relative change in percent
+	UnitPoint   = "pt" // Absolute change in percentage points
+)
+
+// Comparison is the change of a metric between a base run and a new run.
+// Change is in percent for throughput and latency, in percentage points for
+// error rate and wait events share, as given by Unit. Noise is the change
+// which can be explained by interval variance of both runs.
+type Comparison struct {
+	Metric     string
+	Unit       string
+	Base       float64
+	New        float64
+	Change     float64
+	Noise      float64
+	Regression bool
+}
+
+// meanStddev returns mean and standard deviation of values.
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum, sq float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	if len(values) == 1 {
+		return mean, 0
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+// noise returns the relative change in percent which can be explained by
+// interval variance: about two standard errors of the difference of means.
+func noise(base []float64, cur []float64) float64 {
+	bm, bs := meanStddev(base)
+	_, ns := meanStddev(cur)
+	if bm == 0 || len(base) == 0 || len(cur) == 0 {
+		return 0
+	}
+	se := math.Sqrt(bs*bs/float64(len(base)) + ns*ns/float64(len(cur)))
+	return 200 * se / bm
+}
+
+// relative returns the change of cur against base in percent.
+func relative(base float64, cur float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return 100 * (cur - base) / base
+}
+
+// intervalValues returns a field of each interval.
+func intervalValues(r *Results, field func(IntervalStats) float64) []float64 {
+	var values []float64
+	for _, i := range r.Intervals {
+		values = append(values, field(i))
+	}
+	return values
+}
+
+// waitEventShares returns the share of each wait event among samples of
+// its server, in percent. Wait events are prefixed by server when the run
+// monitors several servers, so a primary and a replica are not blended.
+func waitEventShares(r *Results) map[string]float64 {
+	counts := make(map[string]int)
+	totals := make(map[string]int)
+	for _, w := range r.WaitEvents {
+		totals[w.Server] += w.Count
+	}
+	key := func(w WaitEventStat) string {
+		if len(totals) > 1 {
+			return w.Server + " " + w.Event
+		}
+		return w.Event
+	}
+	for _, w := range r.WaitEvents {
+		counts[key(w)] += w.Count
+	}
+	shares := make(map[string]float64, len(counts))
+	for _, w := range r.WaitEvents {
+		if totals[w.Server] > 0 {
+			shares[key(w)] = 100 * float64(counts[key(w)]) / float64(totals[w.Server])
+		}
+	}
+	return shares
+}
+
+// Compare compares a current run with a base run. Throughput and latency
+// changes are regressions when they are worse than thresholds and noise.
+// Error rate and wait event share increases beyond thresholds are regressions.
+func Compare(base *Results, cur *Results, t Thresholds) []Comparison {
+
+	var comparisons []Comparison
+	throughput := func(metric string, b, n float64, field func(IntervalStats) float64) {
+		c := Comparison{Metric: metric, Unit: UnitPercent, Base: b, New: n, Change: relative(b, n),
+			Noise: noise(intervalValues(base, field), intervalValues(cur, field))}
+		c.Regression = -c.Change > t.TPS && -c.Change > c.Noise
+		comparisons = append(comparisons, c)
+	}
+	latency := func(metric string, b, n float64, field func(IntervalStats) float64) {
+		c := Comparison{Metric: metric, Unit: UnitPercent, Base: b, New: n, Change: relative(b, n)}
+		if field != nil {
+			c.Noise = noise(intervalValues(base, field), intervalValues(cur, field))
+		}
+		c.Regression = c.Change > t.Latency && c.Change > c.Noise
+		comparisons = append(comparisons, c)
+	}
+
+	throughput("Average TPS", base.Summary.TPS, cur.Summary.TPS, func(i IntervalStats) float64 { return i.TPS })
+	throughput("Average QPS", base.Summary.QPS, cur.Summary.QPS, func(i IntervalStats) float64 { return i.QPS })
+	latency("Latency mean (ms)", base.Summary.LatencyMean, cur.Summary.LatencyMean, nil)
+	latency("Latency p50 (ms)", base.Summary.LatencyP50, cur.Summary.LatencyP50, func(i IntervalStats) float64 { return i.LatencyP50 })
+	latency("Latency p95 (ms)", base.Summary.LatencyP95, cur.Summary.LatencyP95, func(i IntervalStats) float64 { return i.LatencyP95 })
+	latency("Latency p99 (ms)", base.Summary.LatencyP99, cur.Summary.LatencyP99, func(i IntervalStats) float64 { return i.LatencyP99 })
+
+	// Errors are counted by failed query
+	errorRate := func(s Summary) float64 {
+		if s.Queries == 0 {
+			return 0
+		}
+		return 100 * float64(s.Errors) / float64(s.Queries)
+	}
+	c := Comparison{Metric: "Error rate (%)", Unit: UnitPoint, Base: errorRate(base.Summary), New: errorRate(cur.Summary)}
+	c.Change = c.New - c.Base
+	c.Regression = c.Change > t.ErrorRate
+	comparisons = append(comparisons, c)
+
+	baseShares, newShares := waitEventShares(base), waitEventShares(cur)
+	var events []string
+	for e := range baseShares {
+		events = append(events, e)
+	}
+	for e := range newShares {
+		if _, ok := baseShares[e]; !ok {
+			events = append(events, e)
+		}
+	}
+	sort.Strings(events)
+	for _, e := range events {
+		c := Comparison{Metric: "Wait event " + e + " (%)", Unit: UnitPoint, Base: baseShares[e], New: newShares[e]}
+		c.Change = c.New - c.Base
+		c.Regression = c.Change > t.WaitEvent
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
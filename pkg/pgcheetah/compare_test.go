@@ -0,0 +1,110 @@
+package pgcheetah
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+
+	base := &Results{Summary: Summary{TPS: 1000, QPS: 2000, Xact: 5000, Queries: 10000, Errors: 0, LatencyP99: 10}}
+	cur := &Results{Summary: Summary{TPS: 800, QPS: 1990, Xact: 5000, Queries: 10000, Errors: 300, LatencyP99: 10.5}}
+	for _, tps := range []float64{990, 1010, 1000} {
+		base.Intervals = append(base.Intervals, IntervalStats{TPS: tps, QPS: 2 * tps, LatencyP99: 10})
+	}
+	for _, tps := range []float64{790, 810, 800} {
+		cur.Intervals = append(cur.Intervals, IntervalStats{TPS: tps, QPS: 2 * tps, LatencyP99: 10.5})
+	}
+	base.WaitEvents = []WaitEventStat{{Event: "Lock-tuple", Count: 10}, {Event: "IO-DataFileRead", Count: 90}}
+	cur.WaitEvents = []WaitEventStat{{Event: "Lock-tuple", Count: 50}, {Event: "IO-DataFileRead", Count: 50}}
+
+	var tests = []struct {
+		metric     string
+		change     float64
+		regression bool
+	}{
+		{"Average TPS", -20, true},
+		{"Average QPS", -0.5, false},
+		{"Latency p99 (ms)", 5, false},
+		{"Error rate (%)", 3, true},
+		{"Wait event IO-DataFileRead (%)", -40, false},
+		{"Wait event Lock-tuple (%)", 40, true},
+	}
+
+	comparisons := Compare(base, cur, Thresholds{TPS: 5, Latency: 10, ErrorRate: 1, WaitEvent: 5})
+	byMetric := make(map[string]Comparison)
+	for _, c := range comparisons {
+		byMetric[c.Metric] = c
+	}
+	for i, test := range tests {
+		c, ok := byMetric[test.metric]
+		if !ok {
+			t.Error("Test TestCompare #", i, "Missing metric ", test.metric)
+			continue
+		}
+		if math.Abs(c.Change-test.change) > 0.01 || c.Regression != test.regression {
+			t.Error("Test TestCompare #", i, "Expected ", test.change, test.regression, " got ", c.Change, c.Regression)
+		}
+	}
+	if byMetric["Average TPS"].Noise <= 0 {
+		t.Error("Expected noise estimated from interval variance")
+	}
+	if byMetric["Average TPS"].Unit != UnitPercent || byMetric["Error rate (%)"].Unit != UnitPoint {
+		t.Error("Unexpected units", byMetric["Average TPS"].Unit, byMetric["Error rate (%)"].Unit)
+	}
+}
+
+func TestWaitEventSharesByServer(t *testing.T) {
+
+	r := &Results{WaitEvents: []WaitEventStat{
+		{Server: "primary", Event: "Lock-tuple", Count: 10},
+		{Server: "primary", Event: "IO-DataFileRead", Count: 30},
+		{Server: "replica", Event: "IO-DataFileRead", Count: 500},
+	}}
+	var tests = []struct {
+		key   string
+		share float64
+	}{
+		{"primary Lock-tuple", 25},
+		{"primary IO-DataFileRead", 75},
+		{"replica IO-DataFileRead", 100},
+	}
+
+	shares := waitEventShares(r)
+	for i, test := range tests {
+		if math.Abs(shares[test.key]-test.share) > 0.01 {
+			t.Error("Test TestWaitEventSharesByServer #", i, "Expected ", test.share, " got ", shares[test.key])
+		}
+	}
+}
+
+func TestLoadResults(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "results*.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"type":"metadata","time":"2019-04-26T15:37:20Z","args":["-clients","10"],"clients":10}
+{"type":"interval","elapsed":1,"tps":100}
+{"type":"interval","elapsed":2,"tps":120}
+{"type":"future_record","value":1}
+{"type":"summary","tps":110,"xact":220}
+{"type":"wait_event","event":"Lock-tuple","count":3}
+`)
+	f.Close()
+
+	r, err := LoadResults(f.Name())
+	if err != nil {
+		t.Fatal("Error during loading ", err)
+	}
+	if r.Metadata.Clients != 10 || len(r.Intervals) != 2 || r.Summary.Xact != 220 || len(r.WaitEvents) != 1 {
+		t.Error("Unexpected results", r.Metadata, r.Intervals, r.Summary, r.WaitEvents)
+	}
+
+	if _, err = LoadResults("/nonexistent"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
@@ -0,0 +1,53 @@
+package pgcheetah
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorStats counts errors returned to workers by SQLSTATE.
+// Errors which do not come from postgres, like connection errors,
+// are counted with "client" code. First message of each code is kept.
+type ErrorStats struct {
+	sync.Mutex
+	total    int64
+	Codes    map[string]int64
+	Messages map[string]string
+}
+
+// NewErrorStats returns an empty ErrorStats.
+func NewErrorStats() *ErrorStats {
+	return &ErrorStats{Codes: make(map[string]int64), Messages: make(map[string]string)}
+}
+
+// Add counts an error.
+func (e *ErrorStats) Add(err error) {
+	code := "client"
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		code = pgErr.Code
+	}
+	atomic.AddInt64(&e.total, 1)
+	e.Lock()
+	e.Codes[code]++
+	if _, ok := e.Messages[code]; !ok {
+		e.Messages[code] = err.Error()
+	}
+	e.Unlock()
+}
+
+// Count returns the number of errors.
+func (e *ErrorStats) Count() int64 {
+	return atomic.LoadInt64(&e.total)
+}
+
+// Reset clears errors counters.
+func (e *ErrorStats) Reset() {
+	e.Lock()
+	atomic.StoreInt64(&e.total, 0)
+	e.Codes = make(map[string]int64)
+	e.Messages = make(map[string]string)
+	e.Unlock()
+}
@@ -0,0 +1,136 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ClientGroup is a set of clients with its own dataset, think time, target
+// tps and connection string. Fields missing use command line options,
+// except TPS: a group without TPS is not throttled.
+type ClientGroup struct {
+	Name      string `yaml:"name"`
+	QueryFile string `yaml:"queryfile"`
+	Clients   *int   `yaml:"clients"`
+	ThinkTime struct {
+		Min *int `yaml:"min"`
+		Max *int `yaml:"max"`
+	} `yaml:"thinktime"`
+	TPS     *float64 `yaml:"tps"`
+	ConnStr string   `yaml:"constr"`
+}
+
+// Group names are used in application_name and wait event labels
+var groupName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// GroupApplicationName is the application_name of connections of a group.
+func GroupApplicationName(group string) string {
+	return "pgcheetah/" + group
+}
+
+// ValidateGroups checks group names are unique and phases do not change
+// clients or dataset, which are set by groups.
+func ValidateGroups(groups []ClientGroup, phases []Phase) error {
+
+	names := make(map[string]bool)
+	for _, g := range groups {
+		if !groupName.MatchString(g.Name) {
+			return fmt.Errorf("group name %q must only contain letters, digits and _", g.Name)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("group %s is defined twice", g.Name)
+		}
+		names[g.Name] = true
+		if g.Clients != nil && *g.Clients < 1 {
+			return fmt.Errorf("group %s must have at least one client", g.Name)
+		}
+		if g.TPS != nil && *g.TPS < 0 {
+			return fmt.Errorf("group %s tps must be positive", g.Name)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	for _, p := range phases {
+		if p.Clients != nil || p.QueryFile != "" {
+			return fmt.Errorf("phase %s can not set clients or queryfile with groups", p.Name)
+		}
+	}
+	return nil
+}
+
+// GroupCounters counts transactions, queries, errors and transaction
+// latency of the clients of a group.
+type GroupCounters struct {
+	Xact    int64
+	Queries int64
+	Errors  *ErrorStats
+	Latency *Histogram
+}
+
+// NewGroupCounters returns empty counters.
+func NewGroupCounters() *GroupCounters {
+	return &GroupCounters{Errors: NewErrorStats(), Latency: NewHistogram()}
+}
+
+// Counters reads counters, used to compute group stats like phase stats.
+func (g *GroupCounters) Counters() PhaseCounters {
+	return PhaseCounters{Time: time.Now(), Xact: atomic.LoadInt64(&g.Xact), Queries: atomic.LoadInt64(&g.Queries),
+		Errors: g.Errors.Count(), Latency: g.Latency.Counts(), LatencySum: g.Latency.Sum()}
+}
+
+// Reset clears counters, used when measurement starts.
+func (g *GroupCounters) Reset() {
+	atomic.StoreInt64(&g.Xact, 0)
+	atomic.StoreInt64(&g.Queries, 0)
+	g.Errors.Reset()
+	g.Latency.Reset()
+}
+
+// NewGroupStats returns stats of a group from counters read at measurement
+// start and at the end of the test.
+func NewGroupStats(group string, start PhaseCounters, end PhaseCounters) GroupStats {
+	p := NewPhaseStats(Phase{Name: group}, start, end)
+	return GroupStats{Time: p.Time, Group: group, Elapsed: p.Elapsed, Xact: p.Xact, Queries: p.Queries,
+		Errors: p.Errors, TPS: p.TPS, QPS: p.QPS, LatencyMean: p.LatencyMean, LatencyP50: p.LatencyP50,
+		LatencyP95: p.LatencyP95, LatencyP99: p.LatencyP99}
+}
+
+// GroupWaitEventQuery returns the metric query sampling wait events of
+// client groups, labels are the group name and the wait event.
+func GroupWaitEventQuery(version int, weInterval int) MetricQuery {
+
+	backendType := "AND backend_type = 'client backend'"
+	// Postgres 9.6 has no backend_type
+	if version < 100000 {
+		backendType = ""
+	}
+	return MetricQuery{Name: "group_wait_event", Interval: weInterval, Kind: "sample", SQL: `SELECT
+				  split_part(application_name, '/', 2) as client_group,
+				  wait_event_type || '-' || wait_event as wait_event,
+				  count(*) as count
+				FROM
+				  pg_stat_activity
+				WHERE
+				   wait_event IS NOT NULL
+				   AND
+				   application_name LIKE 'pgcheetah/%'
+				   ` + backendType + `
+				GROUP BY
+				  1,
+				  2;
+`}
+}
+
+// SplitGroupWaitEvent splits a label of GroupWaitEventQuery in group and
+// wait event.
+func SplitGroupWaitEvent(label string) (string, string) {
+	parts := strings.SplitN(label, "-", 2)
+	if len(parts) < 2 {
+		return "", label
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,77 @@
+package pgcheetah
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateGroups(t *testing.T) {
+
+	clients, zero, tps := 10, 0, 100.0
+	var tests = []struct {
+		groups []ClientGroup
+		phases []Phase
+		err    bool
+	}{
+		{[]ClientGroup{{Name: "oltp", Clients: &clients}, {Name: "batch_writer"}}, []Phase{{Name: "spike", TPS: &tps}}, false},
+		{nil, []Phase{{Name: "spike", Clients: &clients}}, false},
+		{[]ClientGroup{{Name: "oltp-users"}}, nil, true},
+		{[]ClientGroup{{Name: ""}}, nil, true},
+		{[]ClientGroup{{Name: "oltp"}, {Name: "oltp"}}, nil, true},
+		{[]ClientGroup{{Name: "oltp", Clients: &zero}}, nil, true},
+		{[]ClientGroup{{Name: "oltp"}}, []Phase{{Name: "spike", Clients: &clients}}, true},
+		{[]ClientGroup{{Name: "oltp"}}, []Phase{{Name: "spike", QueryFile: "spike.sql"}}, true},
+	}
+	for i, test := range tests {
+		if err := ValidateGroups(test.groups, test.phases); (err != nil) != test.err {
+			t.Error("Test TestValidateGroups #", i, "Expected error ", test.err, " got ", err)
+		}
+	}
+}
+
+func TestGroupCounters(t *testing.T) {
+
+	g := NewGroupCounters()
+	g.Xact, g.Queries = 20, 60
+	g.Errors.Add(errors.New("connection reset"))
+	g.Latency.Observe(2 * time.Millisecond)
+	ts := time.Date(2019, 4, 26, 15, 37, 20, 0, time.UTC)
+	end := g.Counters()
+	end.Time = ts.Add(10 * time.Second)
+
+	st := NewGroupStats("oltp", PhaseCounters{Time: ts}, end)
+	if st.Group != "oltp" || st.Xact != 20 || st.Errors != 1 || st.TPS != 2 || st.QPS != 6 || st.LatencyMean != 2 {
+		t.Error("Unexpected group stats ", st)
+	}
+
+	g.Reset()
+	if c := g.Counters(); c.Xact != 0 || c.Queries != 0 || c.Errors != 0 || c.LatencySum != 0 {
+		t.Error("Expected empty counters after reset, got ", c)
+	}
+}
+
+func TestSplitGroupWaitEvent(t *testing.T) {
+
+	var tests = []struct {
+		label string
+		group string
+		event string
+	}{
+		{"oltp-Lock-tuple", "oltp", "Lock-tuple"},
+		{"batch_writer-IO-WALWrite", "batch_writer", "IO-WALWrite"},
+		{"Lock", "", "Lock"},
+	}
+	for i, test := range tests {
+		if group, event := SplitGroupWaitEvent(test.label); group != test.group || event != test.event {
+			t.Error("Test TestSplitGroupWaitEvent #", i, "Expected ", test.group, test.event, " got ", group, event)
+		}
+	}
+	if q := GroupWaitEventQuery(90600, 500); strings.Contains(q.SQL, "backend_type") {
+		t.Error("Expected no backend_type before postgres 10")
+	}
+	if q := GroupWaitEventQuery(120000, 500); !strings.Contains(q.SQL, "backend_type") || q.Kind != "sample" {
+		t.Error("Expected client backends wait events sample, got ", q)
+	}
+}
@@ -0,0 +1,155 @@
+package pgcheetah
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram buckets are log-linear: each doubling of latency is split in
+// histBucketsPerDoubling buckets, from histMin up to histMin * 2^histDoublings.
+// Percentiles are accurate to about 9%.
+const (
+	histMin                = 10 * time.Microsecond
+	histBucketsPerDoubling = 8
+	histDoublings          = 24
+	histBuckets            = histBucketsPerDoubling*histDoublings + 1
+)
+
+// Histogram is a lock free latency histogram, it can be updated
+// concurrently by all workers.
+type Histogram struct {
+	counts [histBuckets + 1]int64 // Last bucket is overflow
+	count  int64
+	sumNs  int64
+}
+
+// NewHistogram returns an empty Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// bucketFor returns the bucket index of a latency.
+func bucketFor(d time.Duration) int {
+	if d <= histMin {
+		return 0
+	}
+	i := int(math.Ceil(histBucketsPerDoubling * math.Log2(float64(d)/float64(histMin))))
+	if i > histBuckets {
+		return histBuckets
+	}
+	return i
+}
+
+// BucketBound returns the upper bound of bucket i.
+func BucketBound(i int) time.Duration {
+	if i >= histBuckets {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(float64(histMin) * math.Pow(2, float64(i)/histBucketsPerDoubling))
+}
+
+// Observe adds a latency.
+func (h *Histogram) Observe(d time.Duration) {
+	atomic.AddInt64(&h.counts[bucketFor(d)], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sumNs, int64(d))
+}
+
+// Count returns the number of latencies observed.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum returns the sum of latencies observed.
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.sumNs))
+}
+
+// Mean returns the average latency.
+func (h *Histogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return h.Sum() / time.Duration(count)
+}
+
+// Percentile returns the upper bound of the bucket containing
+// percentile p (between 0 and 100).
+func (h *Histogram) Percentile(p float64) time.Duration {
+	return PercentileOf(h.Counts(), p)
+}
+
+// PercentileOf returns the upper bound of the bucket containing
+// percentile p (between 0 and 100) of histogram bucket counts.
+// It allows to compute percentiles of an interval from the difference
+// of two Counts.
+func PercentileOf(counts []int64, p float64) time.Duration {
+	var count int64
+	for _, c := range counts {
+		count += c
+	}
+	if count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var cumul int64
+	for i, c := range counts {
+		cumul += c
+		if cumul >= rank {
+			return BucketBound(i)
+		}
+	}
+	return BucketBound(histBuckets)
+}
+
+// Cumulative returns the number of latencies lower or equal to
+// each power of two bucket bound, used by Prometheus exposition.
+// Last value is the total count.
+func (h *Histogram) Cumulative() ([]time.Duration, []int64) {
+	var bounds []time.Duration
+	var counts []int64
+	var cumul int64
+	for i := range h.counts {
+		cumul += atomic.LoadInt64(&h.counts[i])
+		if i%histBucketsPerDoubling == 0 && i < histBuckets {
+			bounds = append(bounds, BucketBound(i))
+			counts = append(counts, cumul)
+		}
+	}
+	return bounds, append(counts, cumul)
+}
+
+// Counts returns the count of each bucket.
+func (h *Histogram) Counts() []int64 {
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return counts
+}
+
+// Buckets returns non empty buckets, upper bounds are in ms.
+// Overflow bucket upper bound is the maximum duration.
+func (h *Histogram) Buckets() []LatencyBucket {
+	var buckets []LatencyBucket
+	for i, c := range h.Counts() {
+		if c != 0 {
+			buckets = append(buckets, LatencyBucket{UpperBound: float64(BucketBound(i)) / float64(time.Millisecond), Count: c})
+		}
+	}
+	return buckets
+}
+
+// Reset clears the histogram, used when measurement starts.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.count, 0)
+	atomic.StoreInt64(&h.sumNs, 0)
+}
@@ -0,0 +1,83 @@
+package pgcheetah
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+
+	h := NewHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 100 || h.Mean() != 50500*time.Microsecond {
+		t.Error("Expected 100 latencies with 50.5ms mean, got", h.Count(), h.Mean())
+	}
+
+	var tests = []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for i, test := range tests {
+		v := h.Percentile(test.p)
+		// Buckets are accurate to about 9%
+		if v < test.expected || float64(v) > float64(test.expected)*1.1 {
+			t.Error("Test TestHistogram #", i, "Expected about ", test.expected, " got ", v)
+		}
+	}
+
+	bounds, counts := h.Cumulative()
+	if len(counts) != len(bounds)+1 || counts[len(counts)-1] != 100 || bounds[0] != histMin {
+		t.Error("Unexpected cumulative buckets", bounds, counts)
+	}
+
+	h.Observe(time.Hour)
+	if h.Percentile(100) != BucketBound(histBuckets) {
+		t.Error("Expected overflow bucket")
+	}
+
+	h.Reset()
+	if h.Count() != 0 || h.Percentile(50) != 0 {
+		t.Error("Expected empty histogram after reset")
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+
+	h := NewHistogram()
+	h.Observe(time.Millisecond)
+	prev := h.Counts()
+	h.Observe(time.Second)
+	h.Observe(time.Second)
+
+	counts := h.Counts()
+	for i := range counts {
+		counts[i] -= prev[i]
+	}
+	if v := PercentileOf(counts, 50); v < time.Second || v > 1100*time.Millisecond {
+		t.Error("Expected interval median about 1s, got", v)
+	}
+	if v := PercentileOf(make([]int64, 3), 50); v != 0 {
+		t.Error("Expected 0 for empty counts, got", v)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+
+	h := NewHistogram()
+	h.Observe(5 * time.Microsecond)
+	h.Observe(5 * time.Microsecond)
+	h.Observe(time.Hour)
+
+	buckets := h.Buckets()
+	if len(buckets) != 2 || buckets[0].UpperBound != 0.01 || buckets[0].Count != 2 || buckets[1].UpperBound < 1e12 {
+		t.Error("Unexpected buckets", buckets)
+	}
+}
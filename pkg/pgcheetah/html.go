@@ -0,0 +1,340 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// chartSeries is a line of a chart.
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// svgChart draws series as lines in an inline SVG chart. x values are
+// seconds since measurement start.
+func svgChart(title string, unit string, x []float64, series []chartSeries) template.HTML {
+
+	const width, height, left, right, top, bottom = 860.0, 260.0, 70.0, 180.0, 30.0, 30.0
+	plotW, plotH := width-left-right, height-top-bottom
+
+	var maxX, maxY float64
+	for _, v := range x {
+		maxX = math.Max(maxX, v)
+	}
+	for _, s := range series {
+		for _, v := range s.Values {
+			maxY = math.Max(maxY, v)
+		}
+	}
+	if maxX == 0 {
+		maxX = 1
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" class="chart">`, width, height)
+	fmt.Fprintf(&b, `<text x="%g" y="18" class="title">%s</text>`, left, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<line x1="%g" y1="%g" x2="%g" y2="%g" class="axis"/>`, left, top+plotH, left+plotW, top+plotH)
+	fmt.Fprintf(&b, `<line x1="%g" y1="%g" x2="%g" y2="%g" class="axis"/>`, left, top, left, top+plotH)
+	for i := 0; i <= 4; i++ {
+		y := top + plotH - plotH*float64(i)/4
+		fmt.Fprintf(&b, `<line x1="%g" y1="%g" x2="%g" y2="%g" class="grid"/>`, left, y, left+plotW, y)
+		fmt.Fprintf(&b, `<text x="%g" y="%g" class="label" text-anchor="end">%.4g%s</text>`, left-5, y+4, maxY*float64(i)/4, template.HTMLEscapeString(unit))
+	}
+	fmt.Fprintf(&b, `<text x="%g" y="%g" class="label">0s</text>`, left, height-8)
+	fmt.Fprintf(&b, `<text x="%g" y="%g" class="label" text-anchor="end">%.fs</text>`, left+plotW, height-8, maxX)
+
+	for i, s := range series {
+		color := chartColors[i%len(chartColors)]
+		var points []string
+		for j, v := range s.Values {
+			if j >= len(x) {
+				break
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", left+plotW*x[j]/maxX, top+plotH-plotH*v/maxY))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`, strings.Join(points, " "), color)
+		fmt.Fprintf(&b, `<rect x="%g" y="%g" width="10" height="10" fill="%s"/>`, left+plotW+10, top+float64(i)*16, color)
+		fmt.Fprintf(&b, `<text x="%g" y="%g" class="label">%s</text>`, left+plotW+25, top+float64(i)*16+9, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// waitEventSeries returns the most sampled wait events of each interval.
+// Wait events are prefixed by server when several servers are monitored.
+func (r *Results) waitEventSeries(n int) ([]float64, []chartSeries) {
+
+	servers := make(map[string]bool)
+	for _, w := range r.IntervalWaitEvents {
+		servers[w.Server] = true
+	}
+	name := func(w WaitEventStat) string {
+		if len(servers) > 1 {
+			return w.Server + " " + w.Event
+		}
+		return w.Event
+	}
+
+	totals := make(map[string]int)
+	byElapsed := make(map[float64]map[string]int)
+	for _, w := range r.IntervalWaitEvents {
+		totals[name(w)] += w.Count
+		if byElapsed[w.Elapsed] == nil {
+			byElapsed[w.Elapsed] = make(map[string]int)
+		}
+		byElapsed[w.Elapsed][name(w)] += w.Count
+	}
+
+	var x []float64
+	for e := range byElapsed {
+		x = append(x, e)
+	}
+	sort.Float64s(x)
+
+	var series []chartSeries
+	for _, event := range Top(totals, n) {
+		s := chartSeries{Name: event}
+		for _, e := range x {
+			s.Values = append(s.Values, float64(byElapsed[e][event]))
+		}
+		series = append(series, s)
+	}
+	return x, series
+}
+
+// charts returns all charts of the report.
+func (r *Results) charts() []template.HTML {
+
+	var x, tps, qps, p50, p95, p99, delay, clients, errors []float64
+	var prevErrors int64
+	for _, i := range r.Intervals {
+		x = append(x, i.Elapsed)
+		tps = append(tps, i.TPS)
+		qps = append(qps, i.QPS)
+		p50 = append(p50, i.LatencyP50)
+		p95 = append(p95, i.LatencyP95)
+		p99 = append(p99, i.LatencyP99)
+		delay = append(delay, float64(i.DelayUs)/1000)
+		clients = append(clients, float64(i.ActiveClients))
+		errors = append(errors, float64(i.Errors-prevErrors))
+		prevErrors = i.Errors
+	}
+
+	charts := []template.HTML{
+		svgChart("Throughput", "", x, []chartSeries{{"TPS", tps}, {"QPS", qps}}),
+		svgChart("Transaction latency", "ms", x, []chartSeries{{"p50", p50}, {"p95", p95}, {"p99", p99}}),
+		svgChart("Delay between transactions", "ms", x, []chartSeries{{"delay", delay}}),
+		svgChart("Active clients", "", x, []chartSeries{{"clients", clients}}),
+		svgChart("Errors", "", x, []chartSeries{{"errors", errors}}),
+	}
+	if wx, ws := r.waitEventSeries(8); len(ws) > 0 {
+		charts = append(charts, svgChart("Wait events", "", wx, ws))
+	}
+	return charts
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pgcheetah report {{.Metadata.Time.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { border: 1px solid #ccc; padding: 3px 8px; text-align: left; font-size: 0.9em; }
+td.num { text-align: right; }
+.chart { display: block; margin-bottom: 1em; }
+.chart .title { font-weight: bold; font-size: 14px; }
+.chart .label { font-size: 11px; fill: #555; }
+.chart .axis { stroke: #333; }
+.chart .grid { stroke: #eee; }
+code { background: #f4f4f4; padding: 2px 4px; }
+.pass { color: #2ca02c; font-weight: bold; }
+.fail { color: #d62728; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>pgcheetah report</h1>
+
+<h2>Configuration</h2>
+<table>
+<tr><th>Start</th><td>{{.Metadata.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Command line</th><td><code>{{range .Metadata.Args}}{{.}} {{end}}</code></td></tr>
+<tr><th>Dataset</th><td>{{.Metadata.QueryFile}} ({{.Metadata.Transactions}} transactions)</td></tr>
+<tr><th>Clients</th><td>{{.Metadata.Clients}}</td></tr>
+<tr><th>Target TPS</th><td>{{.Metadata.TargetTPS}}</td></tr>
+<tr><th>Duration</th><td>{{.Metadata.Duration}}s</td></tr>
+<tr><th>Monitored servers</th><td>{{range .Metadata.Servers}}{{.}} {{end}}</td></tr>
+</table>
+
+{{with .Manifest}}{{if .Version}}
+<h2>Manifest</h2>
+<table>
+<tr><th>pgcheetah version</th><td>{{.Version}} ({{.GoVersion}})</td></tr>
+<tr><th>Start</th><td>{{.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>End</th><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Dataset sha256</th><td><code>{{.DatasetSHA256}}</code></td></tr>
+<tr><th>Parsing</th><td>{{.Transactions}} transactions, {{.Statements}} statements in {{printf "%.1f" .ParseDuration}}s</td></tr>
+<tr><th>Seed</th><td>{{.Seed}}</td></tr>
+<tr><th>Server version</th><td>{{.ServerVersion}}</td></tr>
+<tr><th>Client</th><td>{{.Hostname}}, {{.NumCPU}} CPUs</td></tr>
+</table>
+<table>
+<tr><th>Non default setting</th><th>Value</th></tr>
+{{range $name, $value := .Settings}}<tr><td>{{$name}}</td><td>{{$value}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Flag</th><th>Value</th></tr>
+{{range $name, $value := .Flags}}<tr><td>-{{$name}}</td><td><code>{{$value}}</code></td></tr>
+{{end}}</table>
+{{end}}{{end}}
+
+<h2>Summary</h2>
+<table>
+<tr><th>Elapsed</th><td class="num">{{printf "%.1f" .Summary.Elapsed}}s</td></tr>
+<tr><th>Transactions</th><td class="num">{{.Summary.Xact}}</td></tr>
+<tr><th>Queries</th><td class="num">{{.Summary.Queries}}</td></tr>
+<tr><th>Errors</th><td class="num">{{.Summary.Errors}}</td></tr>
+<tr><th>Average TPS</th><td class="num">{{printf "%.f" .Summary.TPS}}</td></tr>
+<tr><th>Average QPS</th><td class="num">{{printf "%.f" .Summary.QPS}}</td></tr>
+<tr><th>Latency mean</th><td class="num">{{printf "%.3f" .Summary.LatencyMean}}ms</td></tr>
+<tr><th>Latency p50</th><td class="num">{{printf "%.3f" .Summary.LatencyP50}}ms</td></tr>
+<tr><th>Latency p95</th><td class="num">{{printf "%.3f" .Summary.LatencyP95}}ms</td></tr>
+<tr><th>Latency p99</th><td class="num">{{printf "%.3f" .Summary.LatencyP99}}ms</td></tr>
+</table>
+
+{{if .Phases}}
+<h2>Phases</h2>
+<table>
+<tr><th>Phase</th><th>Clients</th><th>Target TPS</th><th>Elapsed</th><th>Transactions</th><th>Errors</th><th>TPS</th><th>QPS</th><th>p50 ms</th><th>p95 ms</th><th>p99 ms</th><th>Top wait events</th></tr>
+{{range .Phases}}<tr><td>{{.Phase}}{{if .Warmup}} (warmup){{end}}</td><td class="num">{{.Clients}}</td><td class="num">{{printf "%.f" .TargetTPS}}</td><td class="num">{{printf "%.1f" .Elapsed}}s</td><td class="num">{{.Xact}}</td><td class="num">{{.Errors}}</td><td class="num">{{printf "%.f" .TPS}}</td><td class="num">{{printf "%.f" .QPS}}</td><td class="num">{{printf "%.3f" .LatencyP50}}</td><td class="num">{{printf "%.3f" .LatencyP95}}</td><td class="num">{{printf "%.3f" .LatencyP99}}</td><td>{{index $.PhaseWaitEvents .Phase}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Groups}}
+<h2>Client groups</h2>
+<table>
+<tr><th>Group</th><th>Clients</th><th>Target TPS</th><th>Transactions</th><th>Errors</th><th>TPS</th><th>QPS</th><th>p50 ms</th><th>p95 ms</th><th>p99 ms</th><th>Top wait events</th></tr>
+{{range .Groups}}<tr><td>{{.Group}}</td><td class="num">{{.Clients}}</td><td class="num">{{printf "%.f" .TargetTPS}}</td><td class="num">{{.Xact}}</td><td class="num">{{.Errors}}</td><td class="num">{{printf "%.f" .TPS}}</td><td class="num">{{printf "%.f" .QPS}}</td><td class="num">{{printf "%.3f" .LatencyP50}}</td><td class="num">{{printf "%.3f" .LatencyP95}}</td><td class="num">{{printf "%.3f" .LatencyP99}}</td><td>{{index $.GroupWaitEvents .Group}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Assertions}}
+<h2>Assertions</h2>
+<table>
+<tr><th>Result</th><th>Assertion</th><th>Limit</th><th>Value</th></tr>
+{{range .Assertions}}<tr><td>{{if .Passed}}<span class="pass">PASS</span>{{else}}<span class="fail">FAIL</span>{{end}}</td><td>{{.Name}}</td><td class="num">{{printf "%g" .Limit}}</td><td class="num">{{printf "%.3f" .Value}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Charts</h2>
+{{range .Charts}}{{.}}
+{{end}}
+
+{{if .Errors}}
+<h2>Errors</h2>
+<table>
+<tr><th>SQLSTATE</th><th>Count</th><th>First message</th></tr>
+{{range .Errors}}<tr><td>{{.SQLState}}</td><td class="num">{{.Count}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .XactClasses}}
+<h2>Transaction classes</h2>
+<table>
+<tr><th>Fingerprint</th><th>Count</th><th>Error rate</th><th>Share of time</th><th>Mean</th><th>p95</th><th>p99</th><th>Statements</th></tr>
+{{range .XactClasses}}<tr><td><code>{{.Fingerprint}}</code></td><td class="num">{{.Count}}</td><td class="num">{{printf "%.2f" .ErrorRate}}%</td><td class="num">{{printf "%.1f" .Share}}%</td><td class="num">{{printf "%.3f" .LatencyMean}}ms</td><td class="num">{{printf "%.3f" .LatencyP95}}ms</td><td class="num">{{printf "%.3f" .LatencyP99}}ms</td><td>{{.Statements}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .WaitEvents}}
+<h2>Wait events</h2>
+<table>
+<tr><th>Server</th><th>Wait event</th><th>Count</th></tr>
+{{range .WaitEvents}}<tr><td>{{.Server}}</td><td>{{.Event}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .ServerStats}}
+<h2>Server statistics</h2>
+<table>
+<tr><th>Server</th><th>Statistic</th><th>Delta</th></tr>
+{{range .ServerStats}}<tr><td>{{.Server}}</td><td>{{.Name}}</td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a self-contained HTML report with charts of the run.
+func (r *Results) WriteHTML(w io.Writer) error {
+	r.Lock()
+	defer r.Unlock()
+
+	waitEvents := make([]WaitEventStat, len(r.WaitEvents))
+	copy(waitEvents, r.WaitEvents)
+	sort.Slice(waitEvents, func(i, j int) bool {
+		if waitEvents[i].Server != waitEvents[j].Server {
+			return waitEvents[i].Server < waitEvents[j].Server
+		}
+		return waitEvents[i].Count > waitEvents[j].Count
+	})
+	// Most expensive transaction classes
+	xactClasses := r.XactClasses
+	if len(xactClasses) > 20 {
+		xactClasses = xactClasses[:20]
+	}
+	return htmlReport.Execute(w, struct {
+		Metadata        Metadata
+		Summary         Summary
+		Assertions      []AssertionResult
+		Errors          []ErrorStat
+		XactClasses     []XactClassStat
+		WaitEvents      []WaitEventStat
+		ServerStats     []ServerStat
+		Charts          []template.HTML
+		Manifest        Manifest
+		Phases          []PhaseStats
+		PhaseWaitEvents map[string]string
+		Groups          []GroupStats
+		GroupWaitEvents map[string]string
+	}{r.Metadata, r.Summary, r.Assertions, r.Errors, xactClasses, waitEvents, r.ServerStats, r.charts(), r.Manifest, r.Phases,
+		r.topWaitEvents(r.PhaseWaitEvents, func(w WaitEventStat) string { return w.Phase }, 3), r.Groups,
+		r.topWaitEvents(r.GroupWaitEvents, func(w WaitEventStat) string { return w.Group }, 3)})
+}
+
+// topWaitEvents returns the n most sampled wait events by key, like the
+// phase or the client group of wait events.
+func (r *Results) topWaitEvents(events []WaitEventStat, key func(WaitEventStat) string, n int) map[string]string {
+	counts := make(map[string]map[string]int)
+	for _, w := range events {
+		event := w.Event
+		if len(r.Metadata.Servers) > 1 {
+			event = w.Server + " " + event
+		}
+		k := key(w)
+		if counts[k] == nil {
+			counts[k] = make(map[string]int)
+		}
+		counts[k][event] += w.Count
+	}
+	top := make(map[string]string)
+	for k, c := range counts {
+		var events []string
+		for _, e := range Top(c, n) {
+			events = append(events, fmt.Sprintf("%s (%d)", e, c[e]))
+		}
+		top[k] = strings.Join(events, ", ")
+	}
+	return top
+}
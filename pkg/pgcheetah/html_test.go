@@ -0,0 +1,58 @@
+package pgcheetah
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteHTML(t *testing.T) {
+
+	var r Results
+	ts := time.Date(2019, 4, 26, 15, 37, 20, 0, time.UTC)
+	r.Write(RecordMetadata, Metadata{Time: ts, Args: []string{"-clients", "10"}, QueryFile: "play.sql", Servers: []string{"s1", "s2"}})
+	for i := 1; i <= 3; i++ {
+		r.Write(RecordInterval, IntervalStats{Time: ts, Elapsed: float64(i), TPS: float64(100 * i), LatencyP99: 2})
+		r.Write(RecordIntervalWaitEvent, WaitEventStat{Elapsed: float64(i), Server: "s1", Event: "Lock-tuple", Count: i})
+		r.Write(RecordIntervalWaitEvent, WaitEventStat{Elapsed: float64(i), Server: "s2", Event: "IO-DataFileRead", Count: 1})
+	}
+	r.Write(RecordSummary, Summary{Time: ts, Xact: 600, TPS: 200})
+	r.Write(RecordError, ErrorStat{SQLState: "23505", Count: 3, Message: "duplicate <key>"})
+	r.Write(RecordPhase, PhaseStats{Phase: "warmup", Warmup: true, TPS: 50})
+	r.Write(RecordPhase, PhaseStats{Phase: "steady", TPS: 200})
+	r.Write(RecordPhaseWaitEvent, WaitEventStat{Server: "s1", Event: "Lock-tuple", Count: 7, Phase: "steady"})
+	r.Write(RecordGroup, GroupStats{Group: "reporting", Clients: 2, TPS: 3})
+	r.Write(RecordGroupWaitEvent, WaitEventStat{Server: "s2", Event: "IO-DataFileRead", Count: 4, Group: "reporting"})
+	r.Write(RecordManifest, Manifest{Time: ts, Start: ts, Version: "2.0.0", DatasetSHA256: "abc123",
+		Settings: map[string]string{"shared_buffers": "4GB"}, Flags: map[string]string{"clients": "10"}})
+	if err := r.Write("unknown", 1); err == nil {
+		t.Error("Expected error for unknown record")
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal("Error during HTML generation ", err)
+	}
+	for _, expected := range []string{
+		"<td>play.sql (0 transactions)</td>",
+		"class=\"title\">Throughput</text>",
+		"s1 Lock-tuple",
+		"duplicate &lt;key&gt;",
+		"<td class=\"num\">600</td>",
+		"<code>abc123</code>",
+		"<td>warmup (warmup)</td>",
+		"<td>s1 Lock-tuple (7)</td>",
+		"<td>reporting</td><td class=\"num\">2</td>",
+		"<td>s2 IO-DataFileRead (4)</td>",
+		"<td>shared_buffers</td><td>4GB</td>",
+		"<td>-clients</td><td><code>10</code></td>",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Error("Expected ", expected, " in HTML report")
+		}
+	}
+	if strings.Count(buf.String(), "<svg") != 6 {
+		t.Error("Expected 6 charts, got", strings.Count(buf.String(), "<svg"))
+	}
+}
@@ -0,0 +1,219 @@
+package pgcheetah
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LockWait describes a session waiting for a lock held by a blocking session.
+// Relation is the relation of the lock, or of the tuple lock held by the
+// waiter when it waits for a transactionid.
+type LockWait struct {
+	Pid          int
+	BlockerPid   int
+	LockType     string
+	Mode         string
+	Relation     string
+	Query        string
+	BlockerQuery string
+}
+
+// LockNode is a session in a blocking tree. Waiters are sessions
+// waiting for a lock held by this session.
+type LockNode struct {
+	Pid      int
+	Mode     string // Requested lock mode, empty for root blockers
+	Relation string
+	Query    string
+	Waiters  []*LockNode
+}
+
+// Size returns the number of waiters in the tree.
+func (n *LockNode) Size() int {
+	size := len(n.Waiters)
+	for _, w := range n.Waiters {
+		size += w.Size()
+	}
+	return size
+}
+
+// LockStats aggregates lock waits sampled during the test.
+// Relations and Statements count how often a relation or a normalized
+// blocking statement appeared in a waiter/blocker pair.
+type LockStats struct {
+	sync.Mutex
+	Samples    int
+	Waits      int
+	Modes      map[string]int
+	Relations  map[string]int
+	Statements map[string]int
+	Largest    []*LockNode // Blocking trees of the sample with most waiters
+	largest    int
+}
+
+// NewLockStats returns an empty LockStats.
+func NewLockStats() *LockStats {
+	return &LockStats{Modes: make(map[string]int), Relations: make(map[string]int), Statements: make(map[string]int)}
+}
+
+// Add aggregates lock waits of a sample.
+func (ls *LockStats) Add(waits []LockWait) {
+	ls.Lock()
+	defer ls.Unlock()
+
+	ls.Samples++
+	ls.Waits += len(waits)
+	for _, w := range waits {
+		ls.Modes[w.Mode]++
+		if w.Relation != "" {
+			ls.Relations[w.Relation]++
+		} else {
+			ls.Relations[w.LockType]++
+		}
+		ls.Statements[NormalizeQuery(w.BlockerQuery)]++
+	}
+
+	if len(waits) > ls.largest {
+		ls.largest = len(waits)
+		ls.Largest = BuildLockTrees(waits)
+	}
+}
+
+// Reset clears aggregated lock waits.
+func (ls *LockStats) Reset() {
+	ls.Lock()
+	defer ls.Unlock()
+	ls.Samples, ls.Waits, ls.largest = 0, 0, 0
+	ls.Modes = make(map[string]int)
+	ls.Relations = make(map[string]int)
+	ls.Statements = make(map[string]int)
+	ls.Largest = nil
+}
+
+// BuildLockTrees builds blocker->waiter trees from lock waits of a sample.
+// Roots are blockers which are not waiting themselves. When all sessions
+// are waiting (deadlock), the lowest pid is used as root.
+func BuildLockTrees(waits []LockWait) []*LockNode {
+
+	nodes := make(map[int]*LockNode)
+	waiting := make(map[int]bool)
+	children := make(map[int][]LockWait)
+	for _, w := range waits {
+		if _, ok := nodes[w.BlockerPid]; !ok {
+			nodes[w.BlockerPid] = &LockNode{Pid: w.BlockerPid, Query: w.BlockerQuery}
+		}
+		waiting[w.Pid] = true
+		children[w.BlockerPid] = append(children[w.BlockerPid], w)
+	}
+
+	var pids []int
+	for pid := range nodes {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	var roots []*LockNode
+	visited := make(map[int]bool)
+	var build func(n *LockNode)
+	build = func(n *LockNode) {
+		visited[n.Pid] = true
+		for _, w := range children[n.Pid] {
+			if visited[w.Pid] {
+				continue
+			}
+			child := &LockNode{Pid: w.Pid, Mode: w.Mode, Relation: w.Relation, Query: w.Query}
+			if child.Relation == "" {
+				child.Relation = w.LockType
+			}
+			n.Waiters = append(n.Waiters, child)
+			build(child)
+		}
+	}
+	for _, pid := range pids {
+		if !waiting[pid] {
+			roots = append(roots, nodes[pid])
+			build(nodes[pid])
+		}
+	}
+	// Remaining blockers are in a wait cycle
+	for _, pid := range pids {
+		if !visited[pid] {
+			roots = append(roots, nodes[pid])
+			build(nodes[pid])
+		}
+	}
+	return roots
+}
+
+// Top returns the n keys having the highest count.
+func Top(m map[string]int, n int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] == m[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return m[keys[i]] > m[keys[j]]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+var lockQuery = `SELECT
+				  wa.pid,
+				  b.pid,
+				  l.locktype,
+				  l.mode,
+				  coalesce(l.relation::regclass::text,
+				    (SELECT t.relation::regclass::text
+				     FROM pg_locks t
+				     WHERE t.pid = wa.pid AND t.locktype = 'tuple' AND t.granted
+				     LIMIT 1),
+				    ''),
+				  coalesce(wa.query, ''),
+				  coalesce(ba.query, '')
+				FROM
+				  pg_stat_activity wa
+				  JOIN pg_locks l ON l.pid = wa.pid AND NOT l.granted
+				  CROSS JOIN LATERAL unnest(pg_blocking_pids(wa.pid)) AS b(pid)
+				  JOIN pg_stat_activity ba ON ba.pid = b.pid
+				WHERE
+				  wa.datname = current_database();
+`
+
+// LockCollector samples pg_locks and pg_blocking_pids() every interval ms
+// and aggregates lock waits in ls.
+func LockCollector(ls *LockStats, connStr *string, interval int) {
+
+	db, err := Connect(*connStr)
+	if err != nil {
+		log.Fatal(err, " Connection params : ", *connStr)
+	}
+	defer db.Close(context.Background())
+
+	for {
+		var waits []LockWait
+		rows, err := db.Query(context.Background(), lockQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			var w LockWait
+			err = rows.Scan(&w.Pid, &w.BlockerPid, &w.LockType, &w.Mode, &w.Relation, &w.Query, &w.BlockerQuery)
+			if err != nil {
+				log.Fatal(err)
+			}
+			waits = append(waits, w)
+		}
+		ls.Add(waits)
+
+		time.Sleep(time.Duration(interval) * time.Millisecond)
+	}
+}
@@ -0,0 +1,39 @@
+package pgcheetah
+
+import "testing"
+
+func TestBuildLockTrees(t *testing.T) {
+
+	waits := []LockWait{
+		{Pid: 2, BlockerPid: 1, LockType: "transactionid", Mode: "ShareLock", Relation: "t1", Query: "UPDATE t1 SET a = 2", BlockerQuery: "UPDATE t1 SET a = 1"},
+		{Pid: 3, BlockerPid: 2, LockType: "tuple", Mode: "ExclusiveLock", Relation: "t1", Query: "UPDATE t1 SET a = 3", BlockerQuery: "UPDATE t1 SET a = 2"},
+		{Pid: 4, BlockerPid: 1, LockType: "relation", Mode: "AccessExclusiveLock", Relation: "t2", Query: "LOCK t2", BlockerQuery: "UPDATE t1 SET a = 1"},
+		// Deadlock between 10 and 11
+		{Pid: 10, BlockerPid: 11, LockType: "transactionid", Mode: "ShareLock"},
+		{Pid: 11, BlockerPid: 10, LockType: "transactionid", Mode: "ShareLock"},
+	}
+
+	trees := BuildLockTrees(waits)
+	if len(trees) != 2 {
+		t.Fatal("Expected 2 trees, got", len(trees))
+	}
+	if trees[0].Pid != 1 || trees[0].Size() != 3 || len(trees[0].Waiters) != 2 {
+		t.Error("Expected tree rooted on pid 1 with 3 waiters, got", trees[0].Pid, trees[0].Size())
+	}
+	if trees[0].Waiters[0].Waiters[0].Pid != 3 {
+		t.Error("Expected pid 3 waiting for pid 2")
+	}
+	if trees[1].Pid != 10 || trees[1].Size() != 1 {
+		t.Error("Expected deadlock tree rooted on pid 10 with 1 waiter, got", trees[1].Pid, trees[1].Size())
+	}
+
+	ls := NewLockStats()
+	ls.Add(waits[:3])
+	ls.Add(waits[:1])
+	if ls.Samples != 2 || ls.Waits != 4 || ls.Relations["t1"] != 3 || len(ls.Largest) != 1 {
+		t.Error("Unexpected lock stats", ls.Samples, ls.Waits, ls.Relations)
+	}
+	if top := Top(ls.Statements, 1); top[0] != "update t1 set a=?" {
+		t.Error("Expected update t1 as top blocking statement, got", top)
+	}
+}
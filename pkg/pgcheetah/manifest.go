@@ -0,0 +1,146 @@
+package pgcheetah
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Version of pgcheetah, it can be set at build time with
+// -ldflags "-X github.com/anayrat/pgcheetah/v2/pkg/pgcheetah.Version=x.y.z"
+var Version = "2.0.0-dev"
+
+// Manifest contains what is needed to reproduce a run: flags, dataset,
+// random seed, server and client environment. Time is the end of the run.
+// Settings are the server settings changed from their default value.
+type Manifest struct {
+	Time          time.Time         `json:"time"`
+	Start         time.Time         `json:"start"`
+	Version       string            `json:"version"`
+	GoVersion     string            `json:"go_version"`
+	Flags         map[string]string `json:"flags"`
+	QueryFile     string            `json:"query_file"`
+	DatasetSHA256 string            `json:"dataset_sha256"`
+	Transactions  int               `json:"transactions"`
+	Statements    int               `json:"statements"`
+	ParseDuration float64           `json:"parse_duration"` // Seconds
+	Seed          int64             `json:"seed"`
+	ServerVersion string            `json:"server_version"`
+	Settings      map[string]string `json:"settings"`
+	Hostname      string            `json:"hostname"`
+	NumCPU        int               `json:"num_cpu"`
+}
+
+// FlagValues returns the value of all flags of fs, including flags left
+// to their default value. Passwords of connection strings are masked.
+func FlagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = MaskPasswords([]string{f.Value.String()})[0]
+	})
+	return values
+}
+
+// HashFile returns the hex encoded sha256 of a file.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ServerSettings returns server_version and settings which do not come
+// from their default value, as displayed by SHOW.
+func ServerSettings(db *pgx.Conn) (string, map[string]string, error) {
+
+	var version string
+	settings := make(map[string]string)
+	ctx := context.Background()
+	if err := db.QueryRow(ctx, "SHOW server_version;").Scan(&version); err != nil {
+		return "", settings, err
+	}
+	rows, err := db.Query(ctx, `SELECT
+				  name,
+				  current_setting(name)
+				FROM
+				  pg_catalog.pg_settings
+				WHERE
+				  source NOT IN ('default', 'override');`)
+	if err != nil {
+		return version, settings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, setting string
+		if err = rows.Scan(&name, &setting); err != nil {
+			return version, settings, err
+		}
+		settings[name] = setting
+	}
+	return version, settings, rows.Err()
+}
+
+// ManifestDiff returns human readable differences between manifests of
+// two runs which may explain a change of results.
+func ManifestDiff(base Manifest, cur Manifest) []string {
+
+	var diff []string
+	if base.Version == "" || cur.Version == "" {
+		return diff
+	}
+	changed := func(name string, b string, c string) {
+		if b != c {
+			diff = append(diff, fmt.Sprintf("%s changed from %q to %q", name, b, c))
+		}
+	}
+	changed("pgcheetah version", base.Version, cur.Version)
+	changed("dataset sha256", base.DatasetSHA256, cur.DatasetSHA256)
+	changed("server version", base.ServerVersion, cur.ServerVersion)
+	changed("client cpus", fmt.Sprint(base.NumCPU), fmt.Sprint(cur.NumCPU))
+
+	var names []string
+	for name := range base.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c, ok := cur.Flags[name]; ok {
+			changed("flag -"+name, base.Flags[name], c)
+		}
+	}
+
+	names = names[:0]
+	for name := range base.Settings {
+		names = append(names, name)
+	}
+	for name := range cur.Settings {
+		if _, ok := base.Settings[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, ok := base.Settings[name]
+		if !ok {
+			b = "default"
+		}
+		c, ok := cur.Settings[name]
+		if !ok {
+			c = "default"
+		}
+		changed("setting "+name, b, c)
+	}
+	return diff
+}
@@ -0,0 +1,75 @@
+package pgcheetah
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHashFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pgcheetah")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "play.sql")
+	if err = ioutil.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if h, err := HashFile(path); err != nil || h != expected {
+		t.Error("Expected ", expected, " got ", h, err)
+	}
+	if _, err = HashFile(filepath.Join(dir, "missing.sql")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestFlagValues(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("clients", 100, "")
+	fs.String("constr", "user=postgres password=secret", "")
+	fs.Parse([]string{"-clients", "10"})
+
+	expected := map[string]string{"clients": "10", "constr": "user=postgres password=****"}
+	if values := FlagValues(fs); !reflect.DeepEqual(values, expected) {
+		t.Error("Expected ", expected, " got ", values)
+	}
+}
+
+func TestManifestDiff(t *testing.T) {
+
+	base := Manifest{Version: "2.0.0", DatasetSHA256: "a", ServerVersion: "16.2", NumCPU: 8,
+		Flags:    map[string]string{"clients": "10", "tps": "100"},
+		Settings: map[string]string{"shared_buffers": "4GB", "work_mem": "8MB"}}
+	cur := Manifest{Version: "2.0.0", DatasetSHA256: "b", ServerVersion: "16.2", NumCPU: 8,
+		Flags:    map[string]string{"clients": "10", "tps": "200"},
+		Settings: map[string]string{"shared_buffers": "8GB", "jit": "off"}}
+
+	var tests = []struct {
+		base     Manifest
+		cur      Manifest
+		expected []string
+	}{
+		{base, cur, []string{
+			`dataset sha256 changed from "a" to "b"`,
+			`flag -tps changed from "100" to "200"`,
+			`setting jit changed from "default" to "off"`,
+			`setting shared_buffers changed from "4GB" to "8GB"`,
+			`setting work_mem changed from "8MB" to "default"`,
+		}},
+		{base, base, nil},
+		{Manifest{}, cur, nil},
+	}
+	for i, test := range tests {
+		if diff := ManifestDiff(test.base, test.cur); !reflect.DeepEqual(diff, test.expected) {
+			t.Error("Test TestManifestDiff #", i, "Expected ", test.expected, " got ", diff)
+		}
+	}
+}
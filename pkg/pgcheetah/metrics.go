@@ -0,0 +1,244 @@
+package pgcheetah
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricQuery is a user defined query polled during the test.
+// The last column of each row is the value, other columns are
+// concatenated with "-" to build a label, like wait events.
+// Kind describes how values are aggregated:
+//   - counter: value is a cumulative counter, difference is reported
+//   - gauge: value is a current value, last, min, average and max are reported
+//   - sample: values are summed at each sample, like wait events
+type MetricQuery struct {
+	Name     string `yaml:"name"`
+	SQL      string `yaml:"sql"`
+	Interval int    `yaml:"interval"` // Polling interval in ms
+	Kind     string `yaml:"kind"`
+}
+
+// MetricValue aggregates values of a label.
+type MetricValue struct {
+	First    float64
+	Last     float64
+	Min      float64
+	Max      float64
+	Sum      float64
+	Samples  int
+	reported float64 // Value at last interval report
+}
+
+// Metric contains aggregated values of a MetricQuery by label.
+type Metric struct {
+	sync.Mutex
+	Query  MetricQuery
+	Values map[string]*MetricValue
+}
+
+// LoadMetricQueries reads metric queries from a YAML file.
+func LoadMetricQueries(path string) ([]MetricQuery, error) {
+
+	var queries []MetricQuery
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(content, &queries)
+	if err != nil {
+		return nil, err
+	}
+	for i := range queries {
+		if err = queries[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
+
+// validate checks a metric query and set default interval.
+func (q *MetricQuery) validate() error {
+	if q.Name == "" || q.SQL == "" {
+		return fmt.Errorf("metric query must have a name and sql")
+	}
+	switch q.Kind {
+	case "":
+		q.Kind = "gauge"
+	case "counter", "gauge", "sample":
+	default:
+		return fmt.Errorf("metric %s: unknown kind %s", q.Name, q.Kind)
+	}
+	if q.Interval <= 0 {
+		q.Interval = 1000
+	}
+	return nil
+}
+
+// NewMetric returns an empty Metric for query q.
+func NewMetric(q MetricQuery) *Metric {
+	return &Metric{Query: q, Values: make(map[string]*MetricValue)}
+}
+
+// Add aggregates a value of a label.
+func (m *Metric) Add(label string, value float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	v, ok := m.Values[label]
+	if !ok {
+		m.Values[label] = &MetricValue{First: value, Last: value, Min: value, Max: value, Sum: value, Samples: 1, reported: value}
+		if m.Query.Kind == "sample" {
+			m.Values[label].reported = 0
+		}
+		return
+	}
+	v.Last = value
+	v.Min = math.Min(v.Min, value)
+	v.Max = math.Max(v.Max, value)
+	v.Sum += value
+	v.Samples++
+}
+
+// Reset clears aggregated values, used when measurement starts.
+func (m *Metric) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.Values = make(map[string]*MetricValue)
+}
+
+// Labels returns metric labels sorted alphabetically.
+func (m *Metric) Labels() []string {
+	m.Lock()
+	defer m.Unlock()
+	labels := make([]string, 0, len(m.Values))
+	for l := range m.Values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Total returns the value of a label over the whole test: the difference
+// for a counter, the last value for a gauge and the sum for a sample.
+func (m *Metric) Total(label string) float64 {
+	m.Lock()
+	defer m.Unlock()
+	v, ok := m.Values[label]
+	if !ok {
+		return 0
+	}
+	switch m.Query.Kind {
+	case "counter":
+		return v.Last - v.First
+	case "sample":
+		return v.Sum
+	}
+	return v.Last
+}
+
+// Interval returns the value of each label since the previous call: the
+// difference for a counter, the last value for a gauge and the sum for a sample.
+func (m *Metric) Interval() map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+	values := make(map[string]float64, len(m.Values))
+	for l, v := range m.Values {
+		switch m.Query.Kind {
+		case "counter":
+			values[l] = v.Last - v.reported
+			v.reported = v.Last
+		case "sample":
+			values[l] = v.Sum - v.reported
+			v.reported = v.Sum
+		default:
+			values[l] = v.Last
+		}
+	}
+	return values
+}
+
+// toFloat converts a value returned by pgx to float64.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int16:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float32:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case interface{ AssignTo(interface{}) error }:
+		var f float64
+		err := n.AssignTo(&f)
+		return f, err
+	}
+	return 0, fmt.Errorf("can not convert %v to float", v)
+}
+
+// MetricCollector runs the metric query every Query.Interval ms
+// and aggregates returned rows in m.
+func MetricCollector(m *Metric, connStr *string) {
+
+	db, err := Connect(*connStr)
+	if err != nil {
+		log.Fatal(err, " Connection params : ", *connStr)
+	}
+	defer db.Close(context.Background())
+	collectMetric(db, m)
+}
+
+// collectMetric polls the metric query on db forever. A failed sample is
+// logged and skipped so a transient error does not stop the test.
+func collectMetric(db *pgx.Conn, m *Metric) {
+	for {
+		if err := sampleMetric(db, m); err != nil {
+			log.Printf("Metric %s: %s", m.Query.Name, err)
+		}
+		time.Sleep(time.Duration(m.Query.Interval) * time.Millisecond)
+	}
+}
+
+// sampleMetric runs the metric query once and aggregates returned rows.
+func sampleMetric(db *pgx.Conn, m *Metric) error {
+
+	rows, err := db.Query(context.Background(), m.Query.SQL)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("query returns no column")
+		}
+		if values[len(values)-1] == nil {
+			continue
+		}
+		value, err := toFloat(values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		labels := make([]string, len(values)-1)
+		for i, l := range values[:len(values)-1] {
+			labels[i] = fmt.Sprint(l)
+		}
+		m.Add(strings.Join(labels, "-"), value)
+	}
+	return rows.Err()
+}
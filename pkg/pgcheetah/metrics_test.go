@@ -0,0 +1,84 @@
+package pgcheetah
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadMetricQueries(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "metrics*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+- name: autovacuum
+  sql: SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'autovacuum worker'
+- name: commits
+  sql: SELECT datname, xact_commit FROM pg_stat_database
+  interval: 500
+  kind: counter
+`)
+	f.Close()
+
+	queries, err := LoadMetricQueries(f.Name())
+	if err != nil {
+		t.Fatal("Error during loading ", err)
+	}
+	if len(queries) != 2 || queries[0].Kind != "gauge" || queries[0].Interval != 1000 || queries[1].Interval != 500 {
+		t.Error("Unexpected metric queries", queries)
+	}
+
+	if err = (&MetricQuery{Name: "x", SQL: "SELECT 1", Kind: "wrong"}).validate(); err == nil {
+		t.Error("Expected error for unknown kind")
+	}
+}
+
+func TestMetric(t *testing.T) {
+
+	var tests = []struct {
+		kind     string
+		total    float64
+		interval float64
+	}{
+		{"counter", 30, 20},
+		{"gauge", 40, 40},
+		{"sample", 80, 50},
+	}
+
+	for i, test := range tests {
+		m := NewMetric(MetricQuery{Name: "m", Kind: test.kind})
+		m.Add("a", 10)
+		m.Add("a", 20)
+		m.Interval()
+		m.Add("a", 10)
+		m.Add("a", 40)
+		if v := m.Total("a"); v != test.total {
+			t.Error("Test TestMetric #", i, "Expected total ", test.total, " got ", v)
+		}
+		if v := m.Interval()["a"]; v != test.interval {
+			t.Error("Test TestMetric #", i, "Expected interval ", test.interval, " got ", v)
+		}
+	}
+}
+
+func TestWaitEvents(t *testing.T) {
+
+	we := NewWaitEvents()
+	we.Add("Lock-tuple", 2)
+	we.Add("Lock-tuple", 3)
+	we.Add("IO-DataFileRead", 1)
+	snap := we.Snapshot()
+	if snap["Lock-tuple"] != 5 || snap["IO-DataFileRead"] != 1 {
+		t.Error("Unexpected wait events", snap)
+	}
+	if q := WaitEventQuery(90600, 500); q.Kind != "sample" || q.Interval != 500 || strings.Contains(q.SQL, "backend_type") {
+		t.Error("Unexpected 9.6 wait event query", q)
+	}
+	if q := WaitEventQuery(170000, 500); !strings.Contains(q.SQL, "backend_type") {
+		t.Error("Unexpected wait event query", q)
+	}
+}
@@ -153,8 +153,55 @@ var (
 	rollbackSavePoint, _ = regexp.Compile(`(?i)^ *ROLLBACK TO SAVEPOINT`)
 	query, _             = regexp.Compile(`.*;[ ]*(--.*)?$`)
 	blanckLine, _        = regexp.Compile(`^ *$`)
+	annotation, _        = regexp.Compile(`^ *-- *pgcheetah: *(.*)$`)
 )
 
+// Annotation holds key=value settings of a "-- pgcheetah:" comment line of
+// the dataset, it applies to the next statement.
+type Annotation map[string]string
+
+// Annotations contains annotations by transaction and statement index.
+type Annotations map[int]map[int]Annotation
+
+// Lookup returns the value of key in annotations of a transaction, from the
+// first statement annotated with it.
+func (a Annotations) Lookup(xact int, key string) (string, bool) {
+	first := -1
+	var value string
+	for i, stmt := range a[xact] {
+		if v, ok := stmt[key]; ok && (first == -1 || i < first) {
+			first, value = i, v
+		}
+	}
+	return value, first != -1
+}
+
+// Annotation keys which are set without value
+var annotationFlags = map[string]bool{"template": true}
+
+// parseAnnotation reads key=value settings of an annotation line, it
+// returns nil when the line is not an annotation.
+func parseAnnotation(line string) (Annotation, error) {
+
+	m := annotation.FindStringSubmatch(line)
+	if m == nil {
+		return nil, nil
+	}
+	a := make(Annotation)
+	for _, f := range strings.Fields(m[1]) {
+		if annotationFlags[f] {
+			a[f] = ""
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid annotation %q, expected key=value", f)
+		}
+		a[kv[0]] = kv[1]
+	}
+	return a, nil
+}
+
 // evaluateLine identify if the input string is a begin, commit or rollback,
 // singleline query or a part of a multiline query.
 // It do not use a real parser, but several regex, it is a naive implementation.
@@ -188,10 +235,17 @@ func (t *txtLineTyp) evaluateLine() (string, bool) {
 // It returns the number of transactions processed.
 // Note : it can handle very long lines which used to be longer than buffer.
 func ParseXact(data map[int][]string, queryFile *string, s *State, debug *bool) (int, error) {
+	return ParseXactAnnotations(data, nil, queryFile, s, debug)
+}
+
+// ParseXactAnnotations works like ParseXact and also loads annotations of
+// statements in ann when it is not nil. Annotation lines are never played.
+func ParseXactAnnotations(data map[int][]string, ann Annotations, queryFile *string, s *State, debug *bool) (int, error) {
 
 	var txtLine txtLineTyp
 	var prevState string
 	var xact int
+	var pending Annotation
 
 	file, err := os.Open(*queryFile)
 	if err != nil {
@@ -212,8 +266,20 @@ func ParseXact(data map[int][]string, queryFile *string, s *State, debug *bool)
 			if *debug {
 				log.Println("Query:", string(line))
 			}
+			a, annErr := parseAnnotation(txtLine.String())
+			if annErr != nil {
+				return xact, annErr
+			}
 			stmtype, isvalid := txtLine.evaluateLine()
-			if isvalid {
+			if a != nil {
+				// Several annotation lines apply to the same statement
+				if pending == nil {
+					pending = make(Annotation)
+				}
+				for k, v := range a {
+					pending[k] = v
+				}
+			} else if isvalid {
 				xact, err = s.newState(stmtype)
 				if err != nil {
 					return xact, err
@@ -226,6 +292,13 @@ func ParseXact(data map[int][]string, queryFile *string, s *State, debug *bool)
 				} else {
 					data[xact] = append(data[xact], txtLine.String())
 				}
+				if pending != nil && ann != nil {
+					if ann[xact] == nil {
+						ann[xact] = make(map[int]Annotation)
+					}
+					ann[xact][len(data[xact])-1] = pending
+				}
+				pending = nil
 				prevState = s.Statedesc
 			}
 			txtLine.Reset()
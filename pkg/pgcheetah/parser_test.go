@@ -2,6 +2,8 @@ package pgcheetah
 
 import "testing"
 import "flag"
+import "io/ioutil"
+import "os"
 
 var queryfile = flag.String("queryfile", "", "file containing queries to play")
 
@@ -107,3 +109,59 @@ func TestParseXact(t *testing.T) {
 	}
 
 }
+
+func TestParseXactAnnotations(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "dataset*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`-- pgcheetah: weight=5
+BEGIN;
+SELECT 1;
+-- pgcheetah: capture=id
+--pgcheetah:  name=last
+INSERT INTO t VALUES (1) RETURNING id;
+COMMIT;
+SELECT 2;
+`)
+	f.Close()
+
+	data := map[int][]string{0: {""}}
+	ann := make(Annotations)
+	s := State{"init", 0, false}
+	debug, path := false, f.Name()
+	xact, err := ParseXactAnnotations(data, ann, &path, &s, &debug)
+	if err != nil {
+		t.Fatal("Error during parsing ", err)
+	}
+	if xact != 2 || len(data[1]) != 4 || data[1][2] != "INSERT INTO t VALUES (1) RETURNING id;" {
+		t.Error("Expected annotations not to be played, got ", data)
+	}
+	if v, ok := ann.Lookup(1, "weight"); !ok || v != "5" {
+		t.Error("Expected weight 5, got ", v)
+	}
+	if a := ann[1][2]; a["capture"] != "id" || a["name"] != "last" {
+		t.Error("Expected annotations of INSERT, got ", ann[1])
+	}
+	if _, ok := ann.Lookup(2, "weight"); ok {
+		t.Error("Expected no weight for transaction 2")
+	}
+
+	var tests = []struct {
+		in  string
+		err bool
+	}{
+		{"-- pgcheetah: weight=2 capture=id", false},
+		{"-- pgcheetah: template weight=2", false},
+		{"-- a comment", false},
+		{"-- pgcheetah: weight", true},
+		{"-- pgcheetah: =2", true},
+	}
+	for i, test := range tests {
+		if _, err := parseAnnotation(test.in); (err != nil) != test.err {
+			t.Error("Test TestParseXactAnnotations #", i, "Expected error ", test.err, " got ", err)
+		}
+	}
+}
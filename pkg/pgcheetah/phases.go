@@ -0,0 +1,90 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase is a step of a test, like warmup, steady state, spike or cooldown.
+// TPS and Clients keep their previous value when not set, QueryFile
+// replaces the dataset of all clients during the phase. Warmup phases must
+// come first, they are excluded from the summary.
+type Phase struct {
+	Name      string   `yaml:"name"`
+	Duration  int      `yaml:"duration"` // Seconds, 0 runs until interrupted
+	TPS       *float64 `yaml:"tps"`
+	Clients   *int     `yaml:"clients"`
+	QueryFile string   `yaml:"queryfile"`
+	Warmup    bool     `yaml:"warmup"`
+}
+
+// ValidatePhases checks names are unique, only the last phase runs until
+// interrupted and warmup phases come first.
+func ValidatePhases(phases []Phase) error {
+
+	names := make(map[string]bool)
+	for i, p := range phases {
+		if p.Name == "" {
+			return fmt.Errorf("phase #%d must have a name", i+1)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("phase %s is defined twice", p.Name)
+		}
+		names[p.Name] = true
+		if p.Duration < 0 || (p.Duration == 0 && i < len(phases)-1) {
+			return fmt.Errorf("phase %s must have a duration, only the last phase can run until interrupted", p.Name)
+		}
+		if p.Clients != nil && *p.Clients < 1 {
+			return fmt.Errorf("phase %s must have at least one client", p.Name)
+		}
+		if p.TPS != nil && *p.TPS < 0 {
+			return fmt.Errorf("phase %s tps must be positive", p.Name)
+		}
+		if p.Warmup && i > 0 && !phases[i-1].Warmup {
+			return fmt.Errorf("warmup phase %s must come before other phases", p.Name)
+		}
+	}
+	if len(phases) > 0 && phases[len(phases)-1].Warmup {
+		return fmt.Errorf("last phase can not be a warmup phase")
+	}
+	return nil
+}
+
+// PhaseCounters are cumulative counters read at a phase boundary.
+type PhaseCounters struct {
+	Time       time.Time
+	Xact       int64
+	Queries    int64
+	Errors     int64
+	Latency    []int64 // Transaction latency histogram counts
+	LatencySum time.Duration
+}
+
+// NewPhaseStats returns stats of a phase from counters read at its start
+// and its end.
+func NewPhaseStats(p Phase, start PhaseCounters, end PhaseCounters) PhaseStats {
+
+	st := PhaseStats{Time: end.Time, Start: start.Time, Phase: p.Name, Warmup: p.Warmup,
+		Elapsed: end.Time.Sub(start.Time).Seconds(), Xact: end.Xact - start.Xact,
+		Queries: end.Queries - start.Queries, Errors: end.Errors - start.Errors}
+	if st.Elapsed > 0 {
+		st.TPS = float64(st.Xact) / st.Elapsed
+		st.QPS = float64(st.Queries) / st.Elapsed
+	}
+	latency := make([]int64, len(end.Latency))
+	var count int64
+	for i := range end.Latency {
+		latency[i] = end.Latency[i]
+		if i < len(start.Latency) {
+			latency[i] -= start.Latency[i]
+		}
+		count += latency[i]
+	}
+	if count > 0 {
+		st.LatencyMean = latencyMs((end.LatencySum - start.LatencySum) / time.Duration(count))
+	}
+	st.LatencyP50 = latencyMs(PercentileOf(latency, 50))
+	st.LatencyP95 = latencyMs(PercentileOf(latency, 95))
+	st.LatencyP99 = latencyMs(PercentileOf(latency, 99))
+	return st
+}
@@ -0,0 +1,50 @@
+package pgcheetah
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValidatePhases(t *testing.T) {
+
+	clients, zero := 10, 0
+	var tests = []struct {
+		phases []Phase
+		err    bool
+	}{
+		{[]Phase{{Name: "warmup", Duration: 60, Warmup: true}, {Name: "steady", Duration: 600}, {Name: "spike", Duration: 60}, {Name: "cooldown"}}, false},
+		{[]Phase{{Name: "steady", Duration: 600, Clients: &clients}}, false},
+		{[]Phase{{Duration: 60}}, true},
+		{[]Phase{{Name: "a", Duration: 60}, {Name: "a", Duration: 60}}, true},
+		{[]Phase{{Name: "a"}, {Name: "b", Duration: 60}}, true},
+		{[]Phase{{Name: "a", Duration: 60}, {Name: "warmup", Duration: 60, Warmup: true}, {Name: "b"}}, true},
+		{[]Phase{{Name: "warmup", Duration: 60, Warmup: true}}, true},
+		{[]Phase{{Name: "a", Duration: 60, Clients: &zero}}, true},
+	}
+	for i, test := range tests {
+		if err := ValidatePhases(test.phases); (err != nil) != test.err {
+			t.Error("Test TestValidatePhases #", i, "Expected error ", test.err, " got ", err)
+		}
+	}
+}
+
+func TestNewPhaseStats(t *testing.T) {
+
+	h := NewHistogram()
+	h.Observe(1 * time.Millisecond)
+	ts := time.Date(2019, 4, 26, 15, 37, 20, 0, time.UTC)
+	start := PhaseCounters{Time: ts, Xact: 100, Queries: 300, Errors: 1, Latency: h.Counts(), LatencySum: h.Sum()}
+	for i := 0; i < 10; i++ {
+		h.Observe(4 * time.Millisecond)
+	}
+	end := PhaseCounters{Time: ts.Add(10 * time.Second), Xact: 110, Queries: 330, Errors: 3, Latency: h.Counts(), LatencySum: h.Sum()}
+
+	st := NewPhaseStats(Phase{Name: "spike"}, start, end)
+	if st.Phase != "spike" || st.Xact != 10 || st.Queries != 30 || st.Errors != 2 || st.Elapsed != 10 || st.TPS != 1 || st.QPS != 3 {
+		t.Error("Unexpected phase stats ", st)
+	}
+	if math.Abs(st.LatencyMean-4) > 0.01 || st.LatencyP50 < 3 || st.LatencyP50 > 5 {
+		t.Error("Expected latencies of phase transactions only, got ", st)
+	}
+}
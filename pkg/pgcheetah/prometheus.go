@@ -0,0 +1,59 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PromSample is a sample of a Prometheus metric with its labels.
+type PromSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels formats labels sorted by name, extra label is added last.
+func promLabels(labels map[string]string, extra ...string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, n := range names {
+		parts = append(parts, n+`="`+promLabelEscaper.Replace(labels[n])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+promLabelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// WritePromMetric writes a metric in Prometheus text exposition format.
+// typ is counter or gauge.
+func WritePromMetric(w io.Writer, name string, help string, typ string, samples ...PromSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, promLabels(s.Labels), strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+}
+
+// WritePromHistogram writes a latency histogram in seconds in Prometheus
+// text exposition format. Buckets are powers of two of the smallest bucket.
+func WritePromHistogram(w io.Writer, name string, help string, h *Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	bounds, counts := h.Cumulative()
+	for i, b := range bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, promLabels(nil, "le", strconv.FormatFloat(b.Seconds(), 'g', -1, 64)), counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, promLabels(nil, "le", "+Inf"), counts[len(counts)-1])
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.Sum().Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, counts[len(counts)-1])
+}
@@ -0,0 +1,46 @@
+package pgcheetah
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePromMetric(t *testing.T) {
+
+	var buf bytes.Buffer
+	WritePromMetric(&buf, "pgcheetah_errors_total", "Errors by SQLSTATE", "counter",
+		PromSample{Labels: map[string]string{"sqlstate": "23505"}, Value: 3},
+		PromSample{Labels: map[string]string{"server": `a"b`, "event": "Lock-tuple"}, Value: 1.5})
+
+	expected := `# HELP pgcheetah_errors_total Errors by SQLSTATE
+# TYPE pgcheetah_errors_total counter
+pgcheetah_errors_total{sqlstate="23505"} 3
+pgcheetah_errors_total{event="Lock-tuple",server="a\"b"} 1.5
+`
+	if buf.String() != expected {
+		t.Error("Expected ", expected, " got ", buf.String())
+	}
+}
+
+func TestWritePromHistogram(t *testing.T) {
+
+	var buf bytes.Buffer
+	h := NewHistogram()
+	h.Observe(15 * time.Microsecond)
+	h.Observe(time.Second)
+	WritePromHistogram(&buf, "pgcheetah_query_latency_seconds", "Query latency", h)
+
+	for _, line := range []string{
+		`pgcheetah_query_latency_seconds_bucket{le="1e-05"} 0`,
+		`pgcheetah_query_latency_seconds_bucket{le="2e-05"} 1`,
+		`pgcheetah_query_latency_seconds_bucket{le="+Inf"} 2`,
+		`pgcheetah_query_latency_seconds_sum 1.000015`,
+		`pgcheetah_query_latency_seconds_count 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Error("Expected line ", line, " in ", buf.String())
+		}
+	}
+}
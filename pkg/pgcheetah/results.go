@@ -0,0 +1,471 @@
+package pgcheetah
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record types written by a ResultWriter
+const (
+	RecordMetadata          = "metadata"
+	RecordInterval          = "interval"
+	RecordIntervalWaitEvent = "interval_wait_event"
+	RecordSummary           = "summary"
+	RecordWaitEvent         = "wait_event"
+	RecordError             = "error"
+	RecordServerStat        = "server_stat"
+	RecordLatencyBucket     = "latency_bucket"
+	RecordXactClass         = "xact_class"
+	RecordAssertion         = "assertion"
+	RecordManifest          = "manifest"
+	RecordPhase             = "phase"
+	RecordPhaseWaitEvent    = "phase_wait_event"
+	RecordGroup             = "group"
+	RecordGroupWaitEvent    = "group_wait_event"
+)
+
+// Metadata describes a run.
+type Metadata struct {
+	Time         time.Time `json:"time"`
+	Args         []string  `json:"args"`
+	QueryFile    string    `json:"query_file"`
+	Transactions int       `json:"transactions"`
+	Clients      int       `json:"clients"`
+	TargetTPS    float64   `json:"target_tps"`
+	Duration     int       `json:"duration"`
+	Servers      []string  `json:"servers"`
+}
+
+// IntervalStats are the stats reported each interval.
+// Elapsed is in seconds since measurement start. Latency percentiles
+// are transaction latencies in ms measured during the interval.
+type IntervalStats struct {
+	Time          time.Time `json:"time"`
+	Elapsed       float64   `json:"elapsed"`
+	TPS           float64   `json:"tps"`
+	QPS           float64   `json:"qps"`
+	Xact          int64     `json:"xact"`
+	Queries       int64     `json:"queries"`
+	Errors        int64     `json:"errors"`
+	DelayUs       int       `json:"delay_us"`
+	ActiveClients int64     `json:"active_clients"`
+	LatencyP50    float64   `json:"latency_p50"`
+	LatencyP95    float64   `json:"latency_p95"`
+	LatencyP99    float64   `json:"latency_p99"`
+	ReplayLag     float64   `json:"replay_lag,omitempty"` // ms, timestamp selection
+}
+
+// Summary contains the final stats of a run. Start is the measurement
+// start, after all clients are launched. Latencies are transaction
+// latencies in ms.
+type Summary struct {
+	Time         time.Time `json:"time"`
+	Start        time.Time `json:"start"`
+	Clients      int       `json:"clients"`
+	Elapsed      float64   `json:"elapsed"`
+	Xact         int64     `json:"xact"`
+	Queries      int64     `json:"queries"`
+	Errors       int64     `json:"errors"`
+	TPS          float64   `json:"tps"`
+	QPS          float64   `json:"qps"`
+	LatencyMean  float64   `json:"latency_mean"`
+	LatencyP50   float64   `json:"latency_p50"`
+	LatencyP95   float64   `json:"latency_p95"`
+	LatencyP99   float64   `json:"latency_p99"`
+	MaxReplayLag float64   `json:"max_replay_lag,omitempty"` // ms, timestamp selection
+}
+
+// PhaseStats contains the stats of a phase. Latencies are transaction
+// latencies in ms.
+type PhaseStats struct {
+	Time        time.Time `json:"time"`
+	Start       time.Time `json:"start"`
+	Phase       string    `json:"phase"`
+	Warmup      bool      `json:"warmup"`
+	Clients     int       `json:"clients"`
+	TargetTPS   float64   `json:"target_tps"`
+	Elapsed     float64   `json:"elapsed"`
+	Xact        int64     `json:"xact"`
+	Queries     int64     `json:"queries"`
+	Errors      int64     `json:"errors"`
+	TPS         float64   `json:"tps"`
+	QPS         float64   `json:"qps"`
+	LatencyMean float64   `json:"latency_mean"`
+	LatencyP50  float64   `json:"latency_p50"`
+	LatencyP95  float64   `json:"latency_p95"`
+	LatencyP99  float64   `json:"latency_p99"`
+}
+
+// GroupStats contains the stats of a client group over the measurement.
+// Latencies are transaction latencies in ms.
+type GroupStats struct {
+	Time        time.Time `json:"time"`
+	Group       string    `json:"group"`
+	Clients     int       `json:"clients"`
+	TargetTPS   float64   `json:"target_tps"`
+	Elapsed     float64   `json:"elapsed"`
+	Xact        int64     `json:"xact"`
+	Queries     int64     `json:"queries"`
+	Errors      int64     `json:"errors"`
+	TPS         float64   `json:"tps"`
+	QPS         float64   `json:"qps"`
+	LatencyMean float64   `json:"latency_mean"`
+	LatencyP50  float64   `json:"latency_p50"`
+	LatencyP95  float64   `json:"latency_p95"`
+	LatencyP99  float64   `json:"latency_p99"`
+}
+
+// WaitEventStat is the number of times a wait event has been sampled on a server.
+// Elapsed is in seconds since measurement start. Phase is only set for wait
+// events sampled during a phase, Group for wait events of a client group.
+type WaitEventStat struct {
+	Time    time.Time `json:"time"`
+	Elapsed float64   `json:"elapsed"`
+	Server  string    `json:"server"`
+	Event   string    `json:"event"`
+	Count   int       `json:"count"`
+	Phase   string    `json:"phase,omitempty"`
+	Group   string    `json:"group,omitempty"`
+}
+
+// ErrorStat is the number of errors of a SQLSTATE.
+type ErrorStat struct {
+	Time     time.Time `json:"time"`
+	SQLState string    `json:"sqlstate"`
+	Count    int64     `json:"count"`
+	Message  string    `json:"message"`
+}
+
+// ServerStat is a server statistics delta between start and end of the test.
+type ServerStat struct {
+	Time   time.Time `json:"time"`
+	Server string    `json:"server"`
+	Name   string    `json:"name"`
+	Value  int64     `json:"value"`
+}
+
+// LatencyBucket is the number of transactions whose latency is lower or
+// equal to UpperBound ms and greater than previous bucket bound.
+type LatencyBucket struct {
+	UpperBound float64 `json:"upper_bound"`
+	Count      int64   `json:"count"`
+}
+
+// XactClassStat contains stats of a transaction class identified by the
+// fingerprint of its normalized statements. Share is the percentage of the
+// total time of all transactions, latencies are in ms.
+type XactClassStat struct {
+	Time         time.Time `json:"time"`
+	Fingerprint  string    `json:"fingerprint"`
+	Statements   string    `json:"statements"`
+	DatasetXacts int       `json:"dataset_xacts"`
+	Count        int64     `json:"count"`
+	Errors       int64     `json:"errors"`
+	ErrorRate    float64   `json:"error_rate"`
+	TotalTime    float64   `json:"total_time"`
+	Share        float64   `json:"share"`
+	LatencyMean  float64   `json:"latency_mean"`
+	LatencyP50   float64   `json:"latency_p50"`
+	LatencyP95   float64   `json:"latency_p95"`
+	LatencyP99   float64   `json:"latency_p99"`
+}
+
+// Results contains all records of a run, it is used to build reports.
+type Results struct {
+	sync.Mutex
+	Metadata           Metadata
+	Intervals          []IntervalStats
+	IntervalWaitEvents []WaitEventStat
+	Summary            Summary
+	WaitEvents         []WaitEventStat
+	Errors             []ErrorStat
+	ServerStats        []ServerStat
+	Latency            []LatencyBucket
+	XactClasses        []XactClassStat
+	Assertions         []AssertionResult
+	Manifest           Manifest
+	Phases             []PhaseStats
+	PhaseWaitEvents    []WaitEventStat
+	Groups             []GroupStats
+	GroupWaitEvents    []WaitEventStat
+}
+
+// Write adds a record to results, it implements ResultWriter.
+func (r *Results) Write(typ string, v interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+	switch rec := reflect.Indirect(reflect.ValueOf(v)).Interface().(type) {
+	case Metadata:
+		r.Metadata = rec
+	case IntervalStats:
+		r.Intervals = append(r.Intervals, rec)
+	case Summary:
+		r.Summary = rec
+	case WaitEventStat:
+		if typ == RecordIntervalWaitEvent {
+			r.IntervalWaitEvents = append(r.IntervalWaitEvents, rec)
+		} else if typ == RecordPhaseWaitEvent {
+			r.PhaseWaitEvents = append(r.PhaseWaitEvents, rec)
+		} else if typ == RecordGroupWaitEvent {
+			r.GroupWaitEvents = append(r.GroupWaitEvents, rec)
+		} else {
+			r.WaitEvents = append(r.WaitEvents, rec)
+		}
+	case ErrorStat:
+		r.Errors = append(r.Errors, rec)
+	case ServerStat:
+		r.ServerStats = append(r.ServerStats, rec)
+	case LatencyBucket:
+		r.Latency = append(r.Latency, rec)
+	case XactClassStat:
+		r.XactClasses = append(r.XactClasses, rec)
+	case AssertionResult:
+		r.Assertions = append(r.Assertions, rec)
+	case Manifest:
+		r.Manifest = rec
+	case PhaseStats:
+		r.Phases = append(r.Phases, rec)
+	case GroupStats:
+		r.Groups = append(r.Groups, rec)
+	default:
+		return fmt.Errorf("unknown record %s", typ)
+	}
+	return nil
+}
+
+// Close does nothing, it implements ResultWriter.
+func (r *Results) Close() error {
+	return nil
+}
+
+// LoadResults reads results of a run written with ndjson output.
+func LoadResults(path string) (*Results, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r Results
+	scanner := bufio.NewScanner(f)
+	// Metadata can contain long command lines
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec struct {
+			Type string `json:"type"`
+		}
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+		var v interface{}
+		switch rec.Type {
+		case RecordMetadata:
+			v = &Metadata{}
+		case RecordInterval:
+			v = &IntervalStats{}
+		case RecordIntervalWaitEvent, RecordWaitEvent, RecordPhaseWaitEvent, RecordGroupWaitEvent:
+			v = &WaitEventStat{}
+		case RecordSummary:
+			v = &Summary{}
+		case RecordError:
+			v = &ErrorStat{}
+		case RecordServerStat:
+			v = &ServerStat{}
+		case RecordLatencyBucket:
+			v = &LatencyBucket{}
+		case RecordXactClass:
+			v = &XactClassStat{}
+		case RecordAssertion:
+			v = &AssertionResult{}
+		case RecordManifest:
+			v = &Manifest{}
+		case RecordPhase:
+			v = &PhaseStats{}
+		case RecordGroup:
+			v = &GroupStats{}
+		default:
+			// Ignore records unknown by this version
+			continue
+		}
+		if err = json.Unmarshal(scanner.Bytes(), v); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+		r.Write(rec.Type, v)
+	}
+	return &r, scanner.Err()
+}
+
+// Passwords in keyword/value connection strings and in URIs
+var (
+	uriPassword   = regexp.MustCompile(`(?i)(postgres(?:ql)?://[^:/@\s]*:)[^@\s]*@`)
+	paramPassword = regexp.MustCompile(`(?i)([?&]password=)[^&\s]*`)
+	dsnPassword   = regexp.MustCompile(`(?i)((?:^|[\s=])password\s*=\s*)(?:'(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// MaskPasswords returns command line arguments with passwords of
+// connection strings replaced by "****", so they can be recorded.
+func MaskPasswords(args []string) []string {
+	masked := make([]string, len(args))
+	for i, a := range args {
+		a = uriPassword.ReplaceAllString(a, "${1}****@")
+		a = paramPassword.ReplaceAllString(a, "${1}****")
+		masked[i] = dsnPassword.ReplaceAllString(a, "${1}****")
+	}
+	return masked
+}
+
+// ResultWriter writes records of a run in a machine readable format.
+type ResultWriter interface {
+	Write(typ string, v interface{}) error
+	Close() error
+}
+
+// NewResultWriter returns a writer for format ndjson or csv. For ndjson,
+// path is the file containing all records, "-" means stdout. For csv, path
+// is a prefix, each record type is written in its own file path_type.csv.
+func NewResultWriter(format string, path string) (ResultWriter, error) {
+	switch format {
+	case "ndjson":
+		if path == "-" {
+			return &ndjsonWriter{w: os.Stdout}, nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &ndjsonWriter{w: f, c: f}, nil
+	case "csv":
+		return &csvWriter{prefix: path, files: make(map[string]*os.File), writers: make(map[string]*csv.Writer)}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %s", format)
+}
+
+// ndjsonWriter writes one JSON object by line, with a "type" field.
+type ndjsonWriter struct {
+	sync.Mutex
+	w io.Writer
+	c io.Closer
+}
+
+func (n *ndjsonWriter) Write(typ string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) < 2 || b[0] != '{' {
+		return fmt.Errorf("record %s is not an object", typ)
+	}
+	var line bytes.Buffer
+	fmt.Fprintf(&line, `{"type":%q`, typ)
+	if len(b) > 2 {
+		line.WriteByte(',')
+	}
+	line.Write(b[1:])
+	line.WriteByte('\n')
+
+	n.Lock()
+	defer n.Unlock()
+	_, err = n.w.Write(line.Bytes())
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	if n.c != nil {
+		return n.c.Close()
+	}
+	return nil
+}
+
+// csvWriter writes each record type in its own csv file. Header is built
+// from json field names, slices and maps are written as JSON.
+type csvWriter struct {
+	sync.Mutex
+	prefix  string
+	files   map[string]*os.File
+	writers map[string]*csv.Writer
+}
+
+func (c *csvWriter) Write(typ string, v interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("record %s is not a struct", typ)
+	}
+	w, ok := c.writers[typ]
+	if !ok {
+		f, err := os.Create(c.prefix + "_" + typ + ".csv")
+		if err != nil {
+			return err
+		}
+		c.files[typ] = f
+		w = csv.NewWriter(f)
+		c.writers[typ] = w
+		if err = w.Write(csvHeader(rv.Type())); err != nil {
+			return err
+		}
+	}
+
+	row := make([]string, rv.NumField())
+	for i := 0; i < rv.NumField(); i++ {
+		switch f := rv.Field(i).Interface().(type) {
+		case time.Time:
+			row[i] = f.Format(time.RFC3339Nano)
+		case string:
+			row[i] = f
+		default:
+			if k := rv.Field(i).Kind(); k == reflect.Slice || k == reflect.Map || k == reflect.Struct {
+				b, err := json.Marshal(f)
+				if err != nil {
+					return err
+				}
+				row[i] = string(b)
+			} else {
+				row[i] = fmt.Sprint(f)
+			}
+		}
+	}
+	if err := w.Write(row); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	for typ, f := range c.files {
+		c.writers[typ].Flush()
+		if e := f.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// csvHeader returns json names of struct fields.
+func csvHeader(t reflect.Type) []string {
+	header := make([]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		header[i] = name
+	}
+	return header
+}
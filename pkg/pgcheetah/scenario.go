@@ -0,0 +1,161 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Scenario describes a test in a YAML file. Each field is the command line
+// option given in its flag tag, fields missing from the file keep their
+// command line value. Paths tagged with "path" are relative to the file,
+// like query files of phases and groups.
+type Scenario struct {
+	Connection struct {
+		ConnStr *string  `yaml:"constr" flag:"constr"`
+		Monitor []string `yaml:"monitor" flag:"monconstr"`
+		Results *string  `yaml:"results" flag:"resultsconstr"`
+	} `yaml:"connection"`
+	Dataset struct {
+		QueryFile    *string  `yaml:"queryfile" flag:"queryfile,path"`
+		Fraction     *float64 `yaml:"fraction" flag:"datasetfraction"`
+		Selection    *string  `yaml:"selection" flag:"selection"`
+		ZipfExponent *float64 `yaml:"zipfexponent" flag:"zipfexponent"`
+		Speed        *float64 `yaml:"speed" flag:"speed"`
+	} `yaml:"dataset"`
+	Clients struct {
+		Count      *int `yaml:"count" flag:"clients"`
+		DelayStart *int `yaml:"delaystart" flag:"delaystart"`
+	} `yaml:"clients"`
+	ThinkTime struct {
+		Min *int `yaml:"min" flag:"thinktimemin"`
+		Max *int `yaml:"max" flag:"thinktimemax"`
+	} `yaml:"thinktime"`
+	Load struct {
+		TPS             *float64 `yaml:"tps" flag:"tps"`
+		Duration        *int     `yaml:"duration" flag:"duration"`
+		DelayXact       *float64 `yaml:"delayxact" flag:"delayxact"`
+		SlowStartFactor *float64 `yaml:"slowstartfactor" flag:"slowstartfactor"`
+		Seed            *int64   `yaml:"seed" flag:"seed"`
+		Warmup          *int     `yaml:"warmup" flag:"warmup"`
+	} `yaml:"load"`
+	Phases     []Phase       `yaml:"phases"`
+	Groups     []ClientGroup `yaml:"groups"`
+	Collectors struct {
+		Interval         *int    `yaml:"interval" flag:"interval"`
+		WeInterval       *int    `yaml:"weinterval" flag:"weinterval"`
+		Locks            *bool   `yaml:"locks" flag:"locks"`
+		LockInterval     *int    `yaml:"lockinterval" flag:"lockinterval"`
+		Pgss             *bool   `yaml:"pgss" flag:"pgss"`
+		PgssLimit        *int    `yaml:"pgsslimit" flag:"pgsslimit"`
+		MetricsFile      *string `yaml:"metricsfile" flag:"metricsfile,path"`
+		XactClasses      *bool   `yaml:"xactclasses" flag:"xactclasses"`
+		XactClassesLimit *int    `yaml:"xactclasseslimit" flag:"xactclasseslimit"`
+	} `yaml:"collectors"`
+	Outputs struct {
+		Output            *string  `yaml:"output" flag:"output"`
+		OutputFile        *string  `yaml:"outputfile" flag:"outputfile"`
+		HTMLReport        *string  `yaml:"htmlreport" flag:"htmlreport"`
+		XactLog           *string  `yaml:"xactlog" flag:"xactlog"`
+		SamplingRate      *float64 `yaml:"samplingrate" flag:"samplingrate"`
+		AggregateInterval *int     `yaml:"aggregateinterval" flag:"aggregateinterval"`
+		PromAddr          *string  `yaml:"promaddr" flag:"promaddr"`
+	} `yaml:"outputs"`
+	Assertions struct {
+		MinTPS       *float64           `yaml:"mintps" flag:"assertmintps"`
+		MaxP99       *float64           `yaml:"maxp99" flag:"assertmaxp99"`
+		MaxErrorRate *float64           `yaml:"maxerrorrate" flag:"assertmaxerrorrate"`
+		MaxWaitEvent map[string]float64 `yaml:"maxwaitevent" flag:"assertmaxwaitevent"`
+	} `yaml:"assertions"`
+	dir string // Directory of the scenario file
+}
+
+// LoadScenario reads a scenario from a YAML file.
+func LoadScenario(path string) (*Scenario, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(content, filepath.Dir(path))
+}
+
+// ParseScenario reads a scenario, relative paths are resolved from dir.
+func ParseScenario(content []byte, dir string) (*Scenario, error) {
+
+	var s Scenario
+	if err := yaml.UnmarshalStrict(content, &s); err != nil {
+		return nil, err
+	}
+	s.dir = dir
+	for i, p := range s.Phases {
+		if p.QueryFile != "" && !filepath.IsAbs(p.QueryFile) {
+			s.Phases[i].QueryFile = filepath.Join(dir, p.QueryFile)
+		}
+	}
+	for i, g := range s.Groups {
+		if g.QueryFile != "" && !filepath.IsAbs(g.QueryFile) {
+			s.Groups[i].QueryFile = filepath.Join(dir, g.QueryFile)
+		}
+	}
+	if err := ValidatePhases(s.Phases); err != nil {
+		return nil, err
+	}
+	if err := ValidateGroups(s.Groups, s.Phases); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Flags returns command line values of fields set in the scenario, by
+// option name. Repeated options have several values.
+func (s *Scenario) Flags() map[string][]string {
+	flags := make(map[string][]string)
+	scenarioFlags(reflect.ValueOf(s).Elem(), s.dir, flags)
+	return flags
+}
+
+// scenarioFlags adds values of fields of v which have a flag tag, and
+// walks nested structs.
+func scenarioFlags(v reflect.Value, dir string, flags map[string][]string) {
+
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		tag := field.Tag.Get("flag")
+		if tag == "" {
+			if value.Kind() == reflect.Struct {
+				scenarioFlags(value, dir, flags)
+			}
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		switch value.Kind() {
+		case reflect.Ptr:
+			if value.IsNil() {
+				continue
+			}
+			s := fmt.Sprint(value.Elem().Interface())
+			if len(opts) > 1 && opts[1] == "path" && s != "" && !filepath.IsAbs(s) {
+				s = filepath.Join(dir, s)
+			}
+			flags[name] = []string{s}
+		case reflect.Slice:
+			for j := 0; j < value.Len(); j++ {
+				flags[name] = append(flags[name], fmt.Sprint(value.Index(j).Interface()))
+			}
+		case reflect.Map:
+			// Maps are repeated key=value options, sorted to be deterministic
+			var entries []string
+			for _, k := range value.MapKeys() {
+				entries = append(entries, fmt.Sprintf("%v=%v", k.Interface(), value.MapIndex(k).Interface()))
+			}
+			sort.Strings(entries)
+			flags[name] = append(flags[name], entries...)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pgcheetah
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScenario(t *testing.T) {
+
+	content := `
+connection:
+  constr: "host=primary dbname=app"
+  monitor:
+    - "host=primary dbname=app"
+    - "host=replica dbname=app"
+dataset:
+  queryfile: play.sql
+clients:
+  count: 200
+load:
+  tps: 1500
+  delayxact: 0
+collectors:
+  locks: true
+  metricsfile: /etc/pgcheetah/metrics.yaml
+assertions:
+  maxwaitevent:
+    Lock: 5
+    IO: 30.5
+phases:
+  - name: warmup
+    duration: 60
+    warmup: true
+  - name: spike
+    duration: 30
+    tps: 3000
+  - name: cooldown
+groups:
+  - name: oltp
+    queryfile: oltp.sql
+    clients: 200
+    tps: 2000
+  - name: reporting
+    clients: 2
+    thinktime:
+      min: 100
+      max: 500
+    constr: "host=replica dbname=app"
+`
+	s, err := ParseScenario([]byte(content), "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"constr":             {"host=primary dbname=app"},
+		"monconstr":          {"host=primary dbname=app", "host=replica dbname=app"},
+		"queryfile":          {"bench/play.sql"},
+		"clients":            {"200"},
+		"tps":                {"1500"},
+		"delayxact":          {"0"},
+		"locks":              {"true"},
+		"metricsfile":        {"/etc/pgcheetah/metrics.yaml"},
+		"assertmaxwaitevent": {"IO=30.5", "Lock=5"},
+	}
+	if flags := s.Flags(); !reflect.DeepEqual(flags, expected) {
+		t.Error("Expected ", expected, " got ", flags)
+	}
+
+	if len(s.Phases) != 3 || !s.Phases[0].Warmup || *s.Phases[1].TPS != 3000 {
+		t.Error("Unexpected phases ", s.Phases)
+	}
+
+	if len(s.Groups) != 2 || s.Groups[0].QueryFile != "bench/oltp.sql" || *s.Groups[1].ThinkTime.Max != 500 ||
+		s.Groups[1].ConnStr != "host=replica dbname=app" {
+		t.Error("Unexpected groups ", s.Groups)
+	}
+
+	if _, err = ParseScenario([]byte("clients:\n  cout: 10\n"), "."); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+	if _, err = ParseScenario([]byte("phases:\n  - name: a\n  - name: b\n"), "."); err == nil {
+		t.Error("Expected error for phase without duration")
+	}
+}
@@ -0,0 +1,292 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Selector picks the transactions played by workers. Strategies are:
+//   - uniform: every transaction of the dataset fraction has the same chance
+//   - weighted: transactions are picked according to their weight
+//   - zipf: transactions are ranked by weight, rank r is picked with a
+//     probability proportional to 1/r^exponent
+//   - sequential: every transaction runs exactly once, in dataset order
+//   - timestamp: every transaction runs exactly once, at its original time
+//     relative to the first transaction, divided by Speed
+//   - session: transactions of an original session run in order at their
+//     original time, see NewSessions
+//
+// Weight of a transaction is given by a "-- pgcheetah: weight=N" annotation,
+// 1 by default. Identical transactions are merged and their weights summed,
+// so transactions occurring more often in the dataset weigh more. Original
+// start time of statements is given by a "-- pgcheetah: ts=T" annotation.
+type Selector struct {
+	Strategy   string
+	Speed      float64                 // Speed of timestamp replay, 2 replays twice faster
+	size       int                     // Dataset size, uniform strategy
+	fraction   float64                 // Fraction of dataset, uniform strategy
+	keys       []int                   // Transactions, by rank for zipf
+	cumulative []float64               // Cumulative weights of keys
+	offsets    map[int][]time.Duration // Statements start from first transaction, timestamp strategy
+	origin     *int64                  // Start of replay, unix ns, shared by sessions
+	next       int64                   // Next transaction of sequential pass
+	finished   int64                   // Finished transactions of sequential pass
+	passed     chan bool               // Closed at the end of sequential pass
+}
+
+// NewSelector returns a selector of transactions of dataset. Only the
+// fraction of the dataset is used, exponent is used by zipf strategy.
+func NewSelector(strategy string, dataset map[int][]string, ann Annotations, fraction float64, exponent float64) (*Selector, error) {
+
+	s := &Selector{Strategy: strategy, Speed: 1, size: len(dataset), fraction: fraction}
+	if strategy == "uniform" {
+		return s, nil
+	}
+
+	// Transactions of the dataset fraction, without empty transactions
+	limit := int(math.Ceil(float64(len(dataset)) * fraction))
+	var keys []int
+	for k := 0; k < limit; k++ {
+		if strings.Join(dataset[k], "") != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("dataset has no transaction to select")
+	}
+
+	switch strategy {
+	case "sequential":
+		s.keys = keys
+		s.passed = make(chan bool)
+		return s, nil
+	case "timestamp":
+		if err := s.schedule(keys, dataset, ann); err != nil {
+			return nil, err
+		}
+		s.passed = make(chan bool)
+		return s, nil
+	case "session":
+		return nil, fmt.Errorf("session selection is built by NewSessions")
+	case "weighted", "zipf":
+	default:
+		return nil, fmt.Errorf("unknown selection %s, expected uniform, weighted, zipf, sequential, timestamp or session", strategy)
+	}
+
+	// Identical transactions are merged, in order of first occurrence
+	var weights []float64
+	index := make(map[string]int)
+	for _, k := range keys {
+		w := 1.0
+		if v, ok := ann.Lookup(k, "weight"); ok {
+			var err error
+			if w, err = strconv.ParseFloat(v, 64); err != nil || w < 0 {
+				return nil, fmt.Errorf("transaction %d weight %q must be a positive number", k, v)
+			}
+		}
+		text := strings.Join(dataset[k], "\n")
+		if i, ok := index[text]; ok {
+			weights[i] += w
+			continue
+		}
+		index[text] = len(s.keys)
+		s.keys = append(s.keys, k)
+		weights = append(weights, w)
+	}
+
+	if strategy == "zipf" {
+		if exponent <= 0 {
+			return nil, fmt.Errorf("zipf exponent must be positive")
+		}
+		// Heaviest transactions are the hot spot
+		ranks := make([]int, len(s.keys))
+		for i := range ranks {
+			ranks[i] = i
+		}
+		sort.SliceStable(ranks, func(i, j int) bool { return weights[ranks[i]] > weights[ranks[j]] })
+		keys := make([]int, len(ranks))
+		for r, i := range ranks {
+			keys[r] = s.keys[i]
+			weights[r] = 1 / math.Pow(float64(r+1), exponent)
+		}
+		s.keys = keys
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+		s.cumulative = append(s.cumulative, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("all transactions have a zero weight")
+	}
+	return s, nil
+}
+
+// ParseTimestamp reads a ts annotation, RFC 3339 time or unix epoch in
+// seconds with an optional fraction.
+func ParseTimestamp(v string) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	epoch, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, expected RFC 3339 or unix epoch", v)
+	}
+	sec := math.Floor(epoch)
+	return time.Unix(int64(sec), int64(math.Round((epoch-sec)*1e6))*1000), nil
+}
+
+// schedule orders transactions by their original start and computes start
+// offset of their statements.
+func (s *Selector) schedule(keys []int, dataset map[int][]string, ann Annotations) error {
+
+	for _, k := range keys {
+		if _, ok := ann[k][0]["ts"]; !ok {
+			return fmt.Errorf("first statement of transaction %d has no ts annotation, required by timestamp selection", k)
+		}
+	}
+	first, err := firstTimestamp(keys, ann)
+	if err != nil {
+		return err
+	}
+	if s.offsets, err = timeline(keys, dataset, ann, first); err != nil {
+		return err
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return s.offsets[keys[i]][0] < s.offsets[keys[j]][0] })
+	s.keys = keys
+	s.origin = new(int64)
+	return nil
+}
+
+// firstTimestamp returns the earliest ts annotation of transactions keys.
+func firstTimestamp(keys []int, ann Annotations) (time.Time, error) {
+
+	var first time.Time
+	for _, k := range keys {
+		for _, a := range ann[k] {
+			v, ok := a["ts"]
+			if !ok {
+				continue
+			}
+			t, err := ParseTimestamp(v)
+			if err != nil {
+				return first, fmt.Errorf("transaction %d: %s", k, err)
+			}
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+	}
+	return first, nil
+}
+
+// timeline returns start offset from first of each statement of
+// transactions keys, played in this order. A statement without timestamp
+// starts right after the previous one.
+func timeline(keys []int, dataset map[int][]string, ann Annotations, first time.Time) (map[int][]time.Duration, error) {
+
+	var offset time.Duration
+	offsets := make(map[int][]time.Duration, len(keys))
+	for _, k := range keys {
+		starts := make([]time.Duration, len(dataset[k]))
+		for i := range dataset[k] {
+			if v, ok := ann[k][i]["ts"]; ok {
+				t, err := ParseTimestamp(v)
+				if err != nil {
+					return nil, fmt.Errorf("transaction %d: %s", k, err)
+				}
+				offset = t.Sub(first)
+			}
+			starts[i] = offset
+		}
+		offsets[k] = starts
+	}
+	return offsets, nil
+}
+
+// StatementStart returns when statement i of transaction xact must start
+// with timestamp strategy, false with other strategies.
+func (s *Selector) StatementStart(xact int, i int) (time.Time, bool) {
+	if s.offsets == nil {
+		return time.Time{}, false
+	}
+	offset := time.Duration(float64(s.offsets[xact][i]) / s.Speed)
+	return time.Unix(0, atomic.LoadInt64(s.origin)).Add(offset), true
+}
+
+// Lag returns how late the next transaction of timestamp replay is, it
+// shows how far replay fell behind the original timeline. It is 0 with
+// other strategies, when replay is on time or done.
+func (s *Selector) Lag() time.Duration {
+
+	if s.offsets == nil {
+		return 0
+	}
+	origin := atomic.LoadInt64(s.origin)
+	next := atomic.LoadInt64(&s.next)
+	if origin == 0 || next >= int64(len(s.keys)) {
+		return 0
+	}
+	lag := time.Since(time.Unix(0, origin).Add(time.Duration(float64(s.offsets[s.keys[next]][0]) / s.Speed)))
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// Next returns the key in dataset of the next transaction to play, false
+// when the sequential pass has no transaction left. r is the random source
+// of the client. Timestamp replay starts at the first call.
+func (s *Selector) Next(r *rand.Rand) (int, bool) {
+
+	switch s.Strategy {
+	case "timestamp":
+		atomic.CompareAndSwapInt64(s.origin, 0, time.Now().UnixNano())
+		fallthrough
+	case "sequential", "session":
+		i := atomic.AddInt64(&s.next, 1) - 1
+		if i >= int64(len(s.keys)) {
+			return 0, false
+		}
+		return s.keys[i], true
+	case "weighted", "zipf":
+		x := r.Float64() * s.cumulative[len(s.cumulative)-1]
+		return s.keys[sort.Search(len(s.cumulative), func(i int) bool { return s.cumulative[i] > x })], true
+	}
+	if s.fraction != 1.0 {
+		return int(float64(r.Intn(s.size)) * s.fraction), true
+	}
+	return r.Intn(s.size), true
+}
+
+// Finish is called once for each transaction returned by Next, when it is
+// played or interrupted. The end of sequential pass is known this way.
+func (s *Selector) Finish() {
+	if s.passed != nil && atomic.AddInt64(&s.finished, 1) == int64(len(s.keys)) {
+		close(s.passed)
+	}
+}
+
+// Passed returns a channel closed at the end of sequential pass. It is nil,
+// thus never ready, with other strategies.
+func (s *Selector) Passed() <-chan bool {
+	return s.passed
+}
+
+// ClientSeed derives the seed of the random source of a client from the
+// seed of the run, so each client has its own reproducible stream.
+func ClientSeed(seed int64, client int) int64 {
+	// splitmix64 finalizer spreads close client ids over the whole range
+	z := uint64(seed) + uint64(client+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
@@ -0,0 +1,237 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var selectorDataset = map[int][]string{
+	0: {""},
+	1: {"BEGIN;", "SELECT 1;", "COMMIT;"},
+	2: {"SELECT 2;"},
+	3: {"SELECT 3;"},
+	4: {"SELECT 2;"},
+}
+
+func TestSelectorWeighted(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	ann := Annotations{3: {0: {"weight": "4"}}}
+	s, err := NewSelector("weighted", selectorDataset, ann, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transaction 2 occurs twice, its weight is 2
+	counts := make(map[int]int)
+	for i := 0; i < 70000; i++ {
+		k, _ := s.Next(r)
+		counts[k]++
+	}
+	var tests = []struct {
+		key  int
+		want int
+	}{
+		{1, 10000},
+		{2, 20000},
+		{3, 40000},
+		{4, 0},
+		{0, 0},
+	}
+	for i, test := range tests {
+		if counts[test.key] < test.want*9/10 || counts[test.key] > test.want*11/10 {
+			t.Error("Test TestSelectorWeighted #", i, "Expected ", test.want, " got ", counts[test.key])
+		}
+	}
+
+	if _, err = NewSelector("weighted", selectorDataset, Annotations{1: {0: {"weight": "-1"}}}, 1, 0); err == nil {
+		t.Error("Expected error with a negative weight")
+	}
+	if _, err = NewSelector("random", selectorDataset, nil, 1, 0); err == nil {
+		t.Error("Expected error with an unknown selection")
+	}
+}
+
+func TestSelectorZipf(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	// Transaction 2 is the most frequent, then 1 with its weight
+	ann := Annotations{1: {1: {"weight": "1.5"}}}
+	s, err := NewSelector("zipf", selectorDataset, ann, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.keys[0] != 2 || s.keys[1] != 1 || s.keys[2] != 3 {
+		t.Error("Expected ranks 2, 1, 3, got ", s.keys)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 11000; i++ {
+		k, _ := s.Next(r)
+		counts[k]++
+	}
+	// Probabilities are 6/11, 3/11 and 2/11
+	if counts[2] < 5400 || counts[2] > 6600 || counts[3] < 1800 || counts[3] > 2200 {
+		t.Error("Unexpected zipf selection ", counts)
+	}
+
+	if _, err = NewSelector("zipf", selectorDataset, nil, 1, 0); err == nil {
+		t.Error("Expected error with a zero exponent")
+	}
+}
+
+func TestSelectorSequential(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	s, err := NewSelector("sequential", selectorDataset, nil, 0.7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{1, 2} {
+		if k, ok := s.Next(r); !ok || k != want {
+			t.Error("Expected transaction ", want, " got ", k, ok)
+		}
+		s.Finish()
+	}
+	if k, ok := s.Next(r); !ok || k != 3 {
+		t.Error("Expected transaction 3 got ", k, ok)
+	}
+	if _, ok := s.Next(r); ok {
+		t.Error("Expected end of sequential pass")
+	}
+	select {
+	case <-s.Passed():
+		t.Error("Expected pass not done before last transaction is finished")
+	default:
+	}
+	s.Finish()
+	select {
+	case <-s.Passed():
+	default:
+		t.Error("Expected pass done")
+	}
+}
+
+func TestSelectorUniform(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	s, err := NewSelector("uniform", selectorDataset, nil, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if k, ok := s.Next(r); !ok || k > 2 {
+			t.Fatal("Expected transaction in dataset fraction, got ", k)
+		}
+	}
+	if s.Passed() != nil {
+		t.Error("Expected no pass with uniform selection")
+	}
+}
+
+func TestClientSeed(t *testing.T) {
+
+	s, err := NewSelector("weighted", selectorDataset, Annotations{3: {0: {"weight": "4"}}}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same seed and client replay the same transactions, other clients not
+	sequence := func(seed int64, client int) []int {
+		r := rand.New(rand.NewSource(ClientSeed(seed, client)))
+		var keys []int
+		for i := 0; i < 20; i++ {
+			k, _ := s.Next(r)
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	a, b, c := fmt.Sprint(sequence(42, 1)), fmt.Sprint(sequence(42, 1)), fmt.Sprint(sequence(42, 2))
+	if a != b {
+		t.Error("Expected same sequence, got ", a, b)
+	}
+	if a == c {
+		t.Error("Expected different sequences for different clients, got ", a)
+	}
+	if ClientSeed(42, 1) == ClientSeed(43, 1) || ClientSeed(42, 1) == ClientSeed(42, 2) {
+		t.Error("Expected different seeds")
+	}
+}
+
+func TestSelectorTimestamp(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	ann := Annotations{
+		1: {0: {"ts": "2019-04-26T09:00:02Z"}, 2: {"ts": "2019-04-26T09:00:03.5Z"}},
+		2: {0: {"ts": "1556269200.25"}},
+		3: {0: {"ts": "2019-04-26T09:00:00Z"}},
+		4: {0: {"ts": "2019-04-26T09:00:10Z"}},
+	}
+	s, err := NewSelector("timestamp", selectorDataset, ann, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Speed = 2
+	if _, ok := s.StatementStart(1, 0); !ok {
+		t.Error("Expected statement start with timestamp selection")
+	}
+
+	// Transactions are replayed in original order from the first call
+	before := time.Now()
+	var tests = []struct {
+		key    int
+		starts []time.Duration
+	}{
+		{3, []time.Duration{0}},
+		{2, []time.Duration{125 * time.Millisecond}},
+		{1, []time.Duration{time.Second, time.Second, 1750 * time.Millisecond}},
+		{4, []time.Duration{5 * time.Second}},
+	}
+	for i, test := range tests {
+		k, ok := s.Next(r)
+		if !ok || k != test.key {
+			t.Error("Test TestSelectorTimestamp #", i, "Expected transaction ", test.key, " got ", k)
+			continue
+		}
+		for j, want := range test.starts {
+			at, _ := s.StatementStart(k, j)
+			if d := at.Sub(before); d < want || d > want+time.Second/2 {
+				t.Error("Test TestSelectorTimestamp #", i, "Expected statement ", j, " start ", want, " got ", d)
+			}
+		}
+	}
+	if _, ok := s.Next(r); ok {
+		t.Error("Expected end of timestamp replay")
+	}
+	if s.Lag() != 0 {
+		t.Error("Expected no lag at the end of replay, got ", s.Lag())
+	}
+
+	if _, err = NewSelector("timestamp", selectorDataset, Annotations{1: {0: {"ts": "yesterday"}}}, 1, 0); err == nil {
+		t.Error("Expected error with an invalid timestamp")
+	}
+	if _, err = NewSelector("timestamp", selectorDataset, nil, 1, 0); err == nil {
+		t.Error("Expected error without timestamps")
+	}
+	ann[1] = map[int]Annotation{1: {"ts": "2019-04-26T09:00:02Z"}}
+	if _, err = NewSelector("timestamp", selectorDataset, ann, 1, 0); err == nil {
+		t.Error("Expected error without timestamp on first statement")
+	}
+}
+
+func TestSelectorLag(t *testing.T) {
+
+	ann := Annotations{1: {0: {"ts": "100"}}, 2: {0: {"ts": "100.05"}}, 3: {0: {"ts": "160"}}, 4: {0: {"ts": "161"}}}
+	s, err := NewSelector("timestamp", selectorDataset, ann, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Lag() != 0 {
+		t.Error("Expected no lag before replay start")
+	}
+	s.Next(rand.New(rand.NewSource(1)))
+	time.Sleep(100 * time.Millisecond)
+	// Second transaction should have started 50ms ago
+	if lag := s.Lag(); lag < 50*time.Millisecond || lag > time.Second {
+		t.Error("Expected lag of about 50ms, got ", lag)
+	}
+}
@@ -0,0 +1,114 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Sessions are the original sessions of a dataset, rebuilt from
+// "-- pgcheetah: session=ID" annotations. Each session is played by its own
+// worker with its own connection, so session state like SET, temporary
+// tables or prepared statements is preserved. Transactions of a session run
+// in dataset order, at their original time when they have a ts annotation.
+type Sessions struct {
+	IDs       []string    // Sessions in order of their original start
+	Selectors []*Selector // Selector of each session
+	origin    int64       // Start of replay, unix ns
+}
+
+// NewSessions rebuilds sessions of the fraction of the dataset. Every
+// transaction must have a session annotation.
+func NewSessions(dataset map[int][]string, ann Annotations, fraction float64) (*Sessions, error) {
+
+	var ids []string
+	keys := make(map[string][]int)
+	var all []int
+	limit := int(math.Ceil(float64(len(dataset)) * fraction))
+	for k := 0; k < limit; k++ {
+		if strings.Join(dataset[k], "") == "" {
+			continue
+		}
+		id, ok := ann.Lookup(k, "session")
+		if !ok {
+			return nil, fmt.Errorf("transaction %d has no session annotation, required by session selection", k)
+		}
+		if keys[id] == nil {
+			ids = append(ids, id)
+		}
+		keys[id] = append(keys[id], k)
+		all = append(all, k)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("dataset has no transaction to select")
+	}
+
+	// Sessions share the same timeline
+	first, err := firstTimestamp(all, ann)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sessions{}
+	for _, id := range ids {
+		offsets, err := timeline(keys[id], dataset, ann, first)
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %s", id, err)
+		}
+		s.IDs = append(s.IDs, id)
+		s.Selectors = append(s.Selectors, &Selector{Strategy: "session", Speed: 1, keys: keys[id], offsets: offsets,
+			origin: &s.origin, passed: make(chan bool)})
+	}
+	sort.Stable(s)
+	return s, nil
+}
+
+// Len, Less and Swap sort sessions by original start.
+func (s *Sessions) Len() int { return len(s.IDs) }
+
+func (s *Sessions) Less(i, j int) bool {
+	return s.start(i) < s.start(j)
+}
+
+func (s *Sessions) Swap(i, j int) {
+	s.IDs[i], s.IDs[j] = s.IDs[j], s.IDs[i]
+	s.Selectors[i], s.Selectors[j] = s.Selectors[j], s.Selectors[i]
+}
+
+// start returns the original start offset of session i.
+func (s *Sessions) start(i int) time.Duration {
+	sel := s.Selectors[i]
+	return sel.offsets[sel.keys[0]][0]
+}
+
+// SetSpeed sets the replay speed of all sessions.
+func (s *Sessions) SetSpeed(speed float64) {
+	for _, sel := range s.Selectors {
+		sel.Speed = speed
+	}
+}
+
+// Start sets the start of replay, origin of the original timeline.
+func (s *Sessions) Start(t time.Time) {
+	atomic.StoreInt64(&s.origin, t.UnixNano())
+}
+
+// SessionStart returns when session i must connect, replay must be started.
+func (s *Sessions) SessionStart(i int) time.Time {
+	at, _ := s.Selectors[i].StatementStart(s.Selectors[i].keys[0], 0)
+	return at
+}
+
+// Lag returns the lag of the latest session, see Selector.Lag.
+func (s *Sessions) Lag() time.Duration {
+
+	var lag time.Duration
+	for _, sel := range s.Selectors {
+		if l := sel.Lag(); l > lag {
+			lag = l
+		}
+	}
+	return lag
+}
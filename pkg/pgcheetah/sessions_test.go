@@ -0,0 +1,75 @@
+package pgcheetah
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestNewSessions(t *testing.T) {
+
+	dataset := map[int][]string{
+		0: {""},
+		1: {"SET search_path TO app;"},
+		2: {"SELECT 1;"},
+		3: {"BEGIN;", "CREATE TEMP TABLE t (id int);", "COMMIT;"},
+		4: {"SELECT * FROM t;"},
+	}
+	ann := Annotations{
+		1: {0: {"session": "b", "ts": "100"}},
+		2: {0: {"session": "a", "ts": "99.5"}},
+		3: {0: {"session": "b"}, 2: {"ts": "101"}},
+		4: {0: {"session": "b", "ts": "102"}},
+	}
+	s, err := NewSessions(dataset, ann, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.IDs) != 2 || s.IDs[0] != "a" || s.IDs[1] != "b" {
+		t.Fatal("Expected sessions a and b, got ", s.IDs)
+	}
+	s.SetSpeed(2)
+	origin := time.Unix(1000, 0)
+	s.Start(origin)
+	if at := s.SessionStart(1); at.Sub(origin) != 250*time.Millisecond {
+		t.Error("Expected session b start after 250ms, got ", at.Sub(origin))
+	}
+
+	// Transactions of a session run in order, a statement without timestamp
+	// starts right after the previous one
+	r := rand.New(rand.NewSource(1))
+	var tests = []struct {
+		key    int
+		starts []time.Duration
+	}{
+		{1, []time.Duration{250 * time.Millisecond}},
+		{3, []time.Duration{250 * time.Millisecond, 250 * time.Millisecond, 750 * time.Millisecond}},
+		{4, []time.Duration{1250 * time.Millisecond}},
+	}
+	sel := s.Selectors[1]
+	for i, test := range tests {
+		k, ok := sel.Next(r)
+		if !ok || k != test.key {
+			t.Error("Test TestNewSessions #", i, "Expected transaction ", test.key, " got ", k)
+			continue
+		}
+		for j, want := range test.starts {
+			if at, _ := sel.StatementStart(k, j); at.Sub(origin) != want {
+				t.Error("Test TestNewSessions #", i, "Expected statement ", j, " start ", want, " got ", at.Sub(origin))
+			}
+		}
+		sel.Finish()
+	}
+	if _, ok := sel.Next(r); ok {
+		t.Error("Expected end of session b")
+	}
+	select {
+	case <-sel.Passed():
+	default:
+		t.Error("Expected session b done")
+	}
+
+	if _, err = NewSessions(dataset, Annotations{1: {0: {"session": "a"}}}, 1); err == nil {
+		t.Error("Expected error with transactions without session")
+	}
+}
@@ -0,0 +1,25 @@
+package pgcheetah
+
+import (
+	"testing"
+)
+
+func TestSparkline(t *testing.T) {
+
+	var tests = []struct {
+		values   []float64
+		width    int
+		expected string
+	}{
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7}, 10, "▁▂▃▄▅▆▇█"},
+		{[]float64{7, 0, 7}, 2, "▁█"},
+		{[]float64{0, 0}, 10, "▁▁"},
+		{nil, 10, ""},
+	}
+
+	for i, test := range tests {
+		if v := Sparkline(test.values, test.width); v != test.expected {
+			t.Error("Test TestSparkline #", i, "Expected ", test.expected, " got ", v)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package pgcheetah
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StatementStats holds pg_stat_statements counters of a queryid.
+// ExecTime is in milliseconds. ClientCalls and ClientTime (milliseconds)
+// are measured by workers for statements found in the dataset.
+type StatementStats struct {
+	QueryID        int64
+	Query          string
+	Calls          int64
+	ExecTime       float64
+	Rows           int64
+	SharedBlksHit  int64
+	SharedBlksRead int64
+	WalBytes       int64
+	ClientCalls    int64
+	ClientTime     float64
+	DatasetXacts   int
+}
+
+// MeanExecTime returns average server execution time in milliseconds.
+func (st StatementStats) MeanExecTime() float64 {
+	if st.Calls == 0 {
+		return 0
+	}
+	return st.ExecTime / float64(st.Calls)
+}
+
+// MeanClientTime returns average latency seen by workers in milliseconds.
+func (st StatementStats) MeanClientTime() float64 {
+	if st.ClientCalls == 0 {
+		return 0
+	}
+	return st.ClientTime / float64(st.ClientCalls)
+}
+
+// StatementsSnapshot contains pg_stat_statements counters by queryid.
+type StatementsSnapshot map[int64]StatementStats
+
+// pg_stat_statements query for postgres 13 and later, total_time has been
+// renamed to total_exec_time and wal counters have been added.
+var pgssQuery = `SELECT
+				  queryid,
+				  min(query),
+				  sum(calls)::bigint,
+				  sum(total_exec_time)::float8,
+				  sum(rows)::bigint,
+				  sum(shared_blks_hit)::bigint,
+				  sum(shared_blks_read)::bigint,
+				  sum(wal_bytes)::bigint
+				FROM
+				  pg_stat_statements
+				WHERE
+				  dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+				  AND queryid IS NOT NULL
+				GROUP BY
+				  queryid;
+`
+
+// pg_stat_statements query before postgres 13
+var pgssQueryLegacy = `SELECT
+				  queryid,
+				  min(query),
+				  sum(calls)::bigint,
+				  sum(total_time)::float8,
+				  sum(rows)::bigint,
+				  sum(shared_blks_hit)::bigint,
+				  sum(shared_blks_read)::bigint,
+				  0::bigint
+				FROM
+				  pg_stat_statements
+				WHERE
+				  dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+				  AND queryid IS NOT NULL
+				GROUP BY
+				  queryid;
+`
+
+// TakeStatementsSnapshot reads pg_stat_statements counters of the current database.
+// pg_stat_statements extension must be installed.
+func TakeStatementsSnapshot(db *pgx.Conn) (StatementsSnapshot, error) {
+
+	snap := make(StatementsSnapshot)
+	version, err := ServerVersion(db)
+	if err != nil {
+		return snap, err
+	}
+	query := pgssQuery
+	if version < 130000 {
+		query = pgssQueryLegacy
+	}
+
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return snap, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st StatementStats
+		err = rows.Scan(&st.QueryID, &st.Query, &st.Calls, &st.ExecTime, &st.Rows,
+			&st.SharedBlksHit, &st.SharedBlksRead, &st.WalBytes)
+		if err != nil {
+			return snap, err
+		}
+		snap[st.QueryID] = st
+	}
+	return snap, rows.Err()
+}
+
+// Delta returns counters difference between s and a previous snapshot for
+// queryid which have been called in the meantime. Result is sorted by
+// execution time, most expensive first.
+func (s StatementsSnapshot) Delta(prev StatementsSnapshot) []StatementStats {
+	var delta []StatementStats
+	for id, st := range s {
+		p := prev[id]
+		st.Calls -= p.Calls
+		st.ExecTime -= p.ExecTime
+		st.Rows -= p.Rows
+		st.SharedBlksHit -= p.SharedBlksHit
+		st.SharedBlksRead -= p.SharedBlksRead
+		st.WalBytes -= p.WalBytes
+		if st.Calls > 0 {
+			delta = append(delta, st)
+		}
+	}
+	sort.Slice(delta, func(i, j int) bool {
+		if delta[i].ExecTime == delta[j].ExecTime {
+			return delta[i].QueryID < delta[j].QueryID
+		}
+		return delta[i].ExecTime > delta[j].ExecTime
+	})
+	return delta
+}
+
+// StatementIndex identifies each distinct normalized statement of a dataset.
+// Workers use it to measure client side latency of each statement.
+type StatementIndex struct {
+	Queries []string       // Normalized statements
+	xactIDs map[int][]int  // Statement id of each query of each transaction
+	ids     map[string]int // Statement id of each normalized statement
+	xacts   []int          // Number of transactions containing each statement
+	calls   []int64
+	timeNs  []int64
+}
+
+// NewStatementIndex normalizes all statements of a dataset.
+func NewStatementIndex(data map[int][]string) *StatementIndex {
+	idx := StatementIndex{xactIDs: make(map[int][]int, len(data)), ids: make(map[string]int)}
+	for xact, queries := range data {
+		seen := make(map[int]bool)
+		for _, q := range queries {
+			norm := NormalizeQuery(q)
+			id, ok := idx.ids[norm]
+			if !ok {
+				id = len(idx.Queries)
+				idx.ids[norm] = id
+				idx.Queries = append(idx.Queries, norm)
+				idx.xacts = append(idx.xacts, 0)
+			}
+			if !seen[id] {
+				idx.xacts[id]++
+				seen[id] = true
+			}
+			idx.xactIDs[xact] = append(idx.xactIDs[xact], id)
+		}
+	}
+	idx.calls = make([]int64, len(idx.Queries))
+	idx.timeNs = make([]int64, len(idx.Queries))
+	return &idx
+}
+
+// Record adds latency of query i of transaction xact.
+func (idx *StatementIndex) Record(xact int, i int, latency time.Duration) {
+	id := idx.xactIDs[xact][i]
+	atomic.AddInt64(&idx.calls[id], 1)
+	atomic.AddInt64(&idx.timeNs[id], int64(latency))
+}
+
+// Reset clears latencies measured by workers.
+func (idx *StatementIndex) Reset() {
+	for i := range idx.calls {
+		atomic.StoreInt64(&idx.calls[i], 0)
+		atomic.StoreInt64(&idx.timeNs[i], 0)
+	}
+}
+
+// Join adds client side latency and number of dataset transactions to
+// pg_stat_statements deltas. Statements are matched on their normalized text.
+func (idx *StatementIndex) Join(stats []StatementStats) {
+	for i := range stats {
+		id, ok := idx.ids[NormalizeQuery(stats[i].Query)]
+		if !ok {
+			continue
+		}
+		stats[i].DatasetXacts = idx.xacts[id]
+		stats[i].ClientCalls = atomic.LoadInt64(&idx.calls[id])
+		stats[i].ClientTime = float64(atomic.LoadInt64(&idx.timeNs[id])) / float64(time.Millisecond)
+	}
+}
+
+// NormalizeQuery returns a normalized form of a statement in order to compare
+// dataset queries with pg_stat_statements queries: comments are removed,
+// literals and $n parameters are replaced by ?, keywords are lowercased and
+// whitespaces are collapsed.
+// Like the parser, it is a naive implementation.
+func NormalizeQuery(q string) string {
+	var b strings.Builder
+	var prev byte // Last significant byte written
+	space := false
+
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+	}
+	isPunct := func(c byte) bool {
+		return strings.IndexByte("(),;=<>+-*/|[]:", c) >= 0
+	}
+	write := func(s string) {
+		if space && b.Len() > 0 && !isPunct(prev) && !isPunct(s[0]) {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+		prev = s[len(s)-1]
+	}
+
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '-' && i+1 < len(q) && q[i+1] == '-':
+			for i < len(q) && q[i] != '\n' {
+				i++
+			}
+			space = true
+		case c == '/' && i+1 < len(q) && q[i+1] == '*':
+			end := strings.Index(q[i+2:], "*/")
+			if end < 0 {
+				i = len(q)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '\'':
+			// String literal, quotes are escaped by doubling them
+			i++
+			for i < len(q) {
+				if q[i] == '\'' {
+					if i+1 < len(q) && q[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			write("?")
+		case c == '"':
+			// Quoted identifier, keep it as is
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				end = len(q) - i - 1
+			}
+			write(q[i : i+end+2])
+			i += end + 2
+		case c == '$' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9':
+			// Parameter $n
+			i++
+			for i < len(q) && q[i] >= '0' && q[i] <= '9' {
+				i++
+			}
+			write("?")
+		case c == '$':
+			// Dollar quoted string $tag$...$tag$
+			end := strings.IndexByte(q[i+1:], '$')
+			if end < 0 {
+				write("$")
+				i++
+				continue
+			}
+			tag := q[i : i+end+2]
+			body := strings.Index(q[i+len(tag):], tag)
+			if body < 0 {
+				i = len(q)
+			} else {
+				i += len(tag) + body + len(tag)
+			}
+			write("?")
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9' ||
+			c == '-' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9' && (b.Len() == 0 || isPunct(prev) && prev != ')'):
+			// Numeric literal, possibly negative
+			i++
+			for i < len(q) && (q[i] >= '0' && q[i] <= '9' || q[i] == '.' ||
+				(q[i] == 'e' || q[i] == 'E') ||
+				(q[i] == '+' || q[i] == '-') && (q[i-1] == 'e' || q[i-1] == 'E')) {
+				i++
+			}
+			write("?")
+		case isIdent(c):
+			start := i
+			for i < len(q) && isIdent(q[i]) {
+				i++
+			}
+			write(strings.ToLower(q[start:i]))
+		default:
+			write(string(c))
+			i++
+		}
+	}
+	return strings.TrimRight(b.String(), ";")
+}
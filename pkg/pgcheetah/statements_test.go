@@ -0,0 +1,68 @@
+package pgcheetah
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+
+	var tests = []struct {
+		in       string
+		expected string
+	}{
+		{"SELECT 1;", "select ?"},
+		{"select $1", "select ?"},
+		{"SELECT * FROM t WHERE id = 42 AND name = 'it''s';", "select*from t where id=? and name=?"},
+		{"SELECT * FROM t WHERE id = $1 AND name = $2", "select*from t where id=? and name=?"},
+		{"select  a,\n  b from t where x in (1, 2, -3.5e2) -- comment", "select a,b from t where x in(?,?,?)"},
+		{"select a /* c */ from \"T\" where b = $tag$x$tag$", "select a from \"T\" where b=?"},
+		{"update t set x = x-1", "update t set x=x-?"},
+		{"select col1 from t2", "select col1 from t2"},
+	}
+
+	for i, test := range tests {
+		if v := NormalizeQuery(test.in); v != test.expected {
+			t.Error("Test TestNormalizeQuery #", i, "Expected ", test.expected, " got ", v)
+		}
+	}
+}
+
+func TestStatementsDelta(t *testing.T) {
+
+	prev := StatementsSnapshot{
+		1: {QueryID: 1, Calls: 10, ExecTime: 5},
+		2: {QueryID: 2, Calls: 3, ExecTime: 1},
+	}
+	cur := StatementsSnapshot{
+		1: {QueryID: 1, Query: "SELECT $1", Calls: 20, ExecTime: 10},
+		2: {QueryID: 2, Calls: 3, ExecTime: 1},
+		3: {QueryID: 3, Query: "SELECT * FROM t WHERE id = $1", Calls: 4, ExecTime: 20},
+	}
+
+	delta := cur.Delta(prev)
+	if len(delta) != 2 {
+		t.Fatal("Expected 2 statements, got", len(delta))
+	}
+	if delta[0].QueryID != 3 || delta[1].QueryID != 1 {
+		t.Error("Expected statements sorted by execution time, got", delta)
+	}
+	if delta[1].Calls != 10 || delta[1].MeanExecTime() != 0.5 {
+		t.Error("Expected 10 calls and 0.5ms mean, got", delta[1].Calls, delta[1].MeanExecTime())
+	}
+
+	data := map[int][]string{
+		0: {"BEGIN;", "SELECT * FROM t WHERE id = 1;", "COMMIT;"},
+		1: {"SELECT * FROM t WHERE id = 2;"},
+	}
+	idx := NewStatementIndex(data)
+	idx.Record(0, 1, 2*time.Millisecond)
+	idx.Record(1, 0, 4*time.Millisecond)
+	idx.Join(delta)
+	if delta[0].DatasetXacts != 2 || delta[0].ClientCalls != 2 || delta[0].MeanClientTime() != 3 {
+		t.Error("Expected 2 transactions, 2 calls and 3ms mean, got", delta[0])
+	}
+	if delta[1].DatasetXacts != 0 {
+		t.Error("Expected statement missing from dataset, got", delta[1])
+	}
+}
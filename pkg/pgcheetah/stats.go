@@ -0,0 +1,161 @@
+package pgcheetah
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"sort"
+)
+
+// ServerStats holds cumulative counters read from postgres statistics views.
+// Keys are prefixed by the view they come from, for example "database.xact_commit".
+type ServerStats map[string]int64
+
+// statsQuery describes a query reading cumulative counters. Each column
+// of the single returned row is stored under Name.column in ServerStats.
+// The query is only run if server version is between MinVersion and MaxVersion.
+type statsQuery struct {
+	Name       string
+	MinVersion int
+	MaxVersion int
+	SQL        string
+}
+
+var statsQueries = []statsQuery{
+	{"database", 90600, 0, `SELECT
+				  coalesce(sum(xact_commit), 0)::bigint AS xact_commit,
+				  coalesce(sum(xact_rollback), 0)::bigint AS xact_rollback,
+				  coalesce(sum(blks_hit), 0)::bigint AS blks_hit,
+				  coalesce(sum(blks_read), 0)::bigint AS blks_read,
+				  coalesce(sum(temp_files), 0)::bigint AS temp_files,
+				  coalesce(sum(temp_bytes), 0)::bigint AS temp_bytes,
+				  coalesce(sum(deadlocks), 0)::bigint AS deadlocks
+				FROM
+				  pg_stat_database
+				WHERE
+				  datname = current_database();`},
+	{"checkpoints", 90600, 169999, `SELECT
+				  checkpoints_timed::bigint AS checkpoints_timed,
+				  checkpoints_req::bigint AS checkpoints_req,
+				  buffers_checkpoint::bigint AS buffers_checkpoint,
+				  buffers_clean::bigint AS buffers_clean
+				FROM
+				  pg_stat_bgwriter;`},
+	// Since postgres 17, checkpoints counters moved to pg_stat_checkpointer
+	{"checkpoints", 170000, 0, `SELECT
+				  c.num_timed::bigint AS checkpoints_timed,
+				  c.num_requested::bigint AS checkpoints_req,
+				  c.buffers_written::bigint AS buffers_checkpoint,
+				  b.buffers_clean::bigint AS buffers_clean
+				FROM
+				  pg_stat_checkpointer c,
+				  pg_stat_bgwriter b;`},
+	{"wal", 90600, 99999, `SELECT
+				  pg_xlog_location_diff(pg_current_xlog_location(), '0/0')::bigint AS wal_bytes
+				WHERE
+				  NOT pg_is_in_recovery();`},
+	{"wal", 100000, 139999, `SELECT
+				  pg_wal_lsn_diff(pg_current_wal_lsn(), '0/0')::bigint AS wal_bytes
+				WHERE
+				  NOT pg_is_in_recovery();`},
+	{"wal", 140000, 0, `SELECT
+				  wal_records::bigint AS wal_records,
+				  wal_fpi::bigint AS wal_fpi,
+				  wal_bytes::bigint AS wal_bytes
+				FROM
+				  pg_stat_wal;`},
+	{"io", 160000, 0, `SELECT
+				  coalesce(sum(reads), 0)::bigint AS reads,
+				  coalesce(sum(writes), 0)::bigint AS writes,
+				  coalesce(sum(extends), 0)::bigint AS extends,
+				  coalesce(sum(hits), 0)::bigint AS hits,
+				  coalesce(sum(evictions), 0)::bigint AS evictions,
+				  coalesce(sum(fsyncs), 0)::bigint AS fsyncs
+				FROM
+				  pg_stat_io;`},
+	{"statio_user_tables", 90600, 0, `SELECT
+				  coalesce(sum(heap_blks_read), 0)::bigint AS heap_blks_read,
+				  coalesce(sum(heap_blks_hit), 0)::bigint AS heap_blks_hit,
+				  coalesce(sum(idx_blks_read), 0)::bigint AS idx_blks_read,
+				  coalesce(sum(idx_blks_hit), 0)::bigint AS idx_blks_hit,
+				  coalesce(sum(toast_blks_read), 0)::bigint AS toast_blks_read,
+				  coalesce(sum(toast_blks_hit), 0)::bigint AS toast_blks_hit
+				FROM
+				  pg_statio_user_tables;`},
+}
+
+// Connect opens a connection using simple protocol in order to work with pgbouncer.
+func Connect(connStr string) (*pgx.Conn, error) {
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.PreferSimpleProtocol = true
+	return pgx.ConnectConfig(context.Background(), cfg)
+}
+
+// ServerVersion returns server_version_num of the server behind db.
+func ServerVersion(db *pgx.Conn) (int, error) {
+	var version int
+	err := db.QueryRow(context.Background(), "SELECT setting::int FROM pg_catalog.pg_settings WHERE name = 'server_version_num';").Scan(&version)
+	return version, err
+}
+
+// TakeSnapshot reads cumulative statistics from pg_stat_database,
+// pg_stat_bgwriter/pg_stat_checkpointer, pg_stat_wal, pg_stat_io and
+// pg_statio_user_tables. Views which do not exist in server version are skipped.
+func TakeSnapshot(db *pgx.Conn) (ServerStats, error) {
+
+	stats := make(ServerStats)
+	version, err := ServerVersion(db)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, q := range statsQueries {
+		if version < q.MinVersion || (q.MaxVersion != 0 && version > q.MaxVersion) {
+			continue
+		}
+		rows, err := db.Query(context.Background(), q.SQL)
+		if err != nil {
+			return stats, fmt.Errorf("%s snapshot: %s", q.Name, err)
+		}
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return stats, fmt.Errorf("%s snapshot: %s", q.Name, err)
+			}
+			for i, fd := range rows.FieldDescriptions() {
+				if v, ok := values[i].(int64); ok {
+					stats[q.Name+"."+string(fd.Name)] = v
+				}
+			}
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return stats, fmt.Errorf("%s snapshot: %s", q.Name, rows.Err())
+		}
+	}
+	return stats, nil
+}
+
+// Delta returns counters difference between s and a previous snapshot.
+// Counters missing from prev are considered as 0.
+func (s ServerStats) Delta(prev ServerStats) ServerStats {
+	delta := make(ServerStats, len(s))
+	for k, v := range s {
+		delta[k] = v - prev[k]
+	}
+	return delta
+}
+
+// Keys returns counter names sorted alphabetically.
+func (s ServerStats) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
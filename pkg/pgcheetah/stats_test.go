@@ -0,0 +1,30 @@
+package pgcheetah
+
+import "testing"
+
+func TestServerStatsDelta(t *testing.T) {
+
+	prev := ServerStats{"database.xact_commit": 10, "wal.wal_bytes": 100}
+	cur := ServerStats{"database.xact_commit": 15, "wal.wal_bytes": 250, "io.reads": 3}
+
+	var tests = []struct {
+		key      string
+		expected int64
+	}{
+		{"database.xact_commit", 5},
+		{"wal.wal_bytes", 150},
+		{"io.reads", 3},
+	}
+
+	delta := cur.Delta(prev)
+	for i, test := range tests {
+		if delta[test.key] != test.expected {
+			t.Error("Test TestServerStatsDelta #", i, "Expected ", test.expected, " got ", delta[test.key])
+		}
+	}
+
+	keys := delta.Keys()
+	if len(keys) != 3 || keys[0] != "database.xact_commit" {
+		t.Error("Expected sorted keys, got", keys)
+	}
+}
@@ -0,0 +1,312 @@
+package pgcheetah
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v4"
+)
+
+// resultsSchema creates the results schema if missing. Each run has a row
+// in runs table, other tables reference it with run_id.
+var resultsSchema = []string{
+	`CREATE SCHEMA IF NOT EXISTS pgcheetah`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.runs (
+				  run_id bigserial PRIMARY KEY,
+				  start_time timestamptz NOT NULL,
+				  end_time timestamptz,
+				  args text[],
+				  query_file text,
+				  transactions int,
+				  clients int,
+				  target_tps float8,
+				  duration int,
+				  servers text[],
+				  elapsed float8,
+				  xact bigint,
+				  queries bigint,
+				  errors bigint,
+				  tps float8,
+				  qps float8,
+				  latency_mean float8,
+				  latency_p50 float8,
+				  latency_p95 float8,
+				  latency_p99 float8
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.intervals (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  time timestamptz NOT NULL,
+				  elapsed float8,
+				  tps float8,
+				  qps float8,
+				  xact bigint,
+				  queries bigint,
+				  errors bigint,
+				  delay_us bigint,
+				  active_clients bigint,
+				  latency_p50 float8,
+				  latency_p95 float8,
+				  latency_p99 float8
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.latency_histogram (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  upper_bound float8 NOT NULL,
+				  count bigint NOT NULL
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.wait_events (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  time timestamptz NOT NULL,
+				  elapsed float8,
+				  server text,
+				  event text,
+				  count bigint,
+				  total boolean NOT NULL
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.errors (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  sqlstate text,
+				  count bigint,
+				  message text
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.server_stats (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  server text,
+				  name text,
+				  value bigint
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.xact_classes (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  fingerprint text,
+				  statements text,
+				  dataset_xacts int,
+				  count bigint,
+				  errors bigint,
+				  error_rate float8,
+				  total_time float8,
+				  share float8,
+				  latency_mean float8,
+				  latency_p50 float8,
+				  latency_p95 float8,
+				  latency_p99 float8
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.assertions (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  name text,
+				  "limit" float8,
+				  value float8,
+				  passed boolean
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.phases (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  phase text,
+				  warmup boolean,
+				  start_time timestamptz,
+				  end_time timestamptz,
+				  clients int,
+				  target_tps float8,
+				  elapsed float8,
+				  xact bigint,
+				  queries bigint,
+				  errors bigint,
+				  tps float8,
+				  qps float8,
+				  latency_mean float8,
+				  latency_p50 float8,
+				  latency_p95 float8,
+				  latency_p99 float8
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.phase_wait_events (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  phase text,
+				  server text,
+				  event text,
+				  count bigint
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.groups (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  client_group text,
+				  clients int,
+				  target_tps float8,
+				  elapsed float8,
+				  xact bigint,
+				  queries bigint,
+				  errors bigint,
+				  tps float8,
+				  qps float8,
+				  latency_mean float8,
+				  latency_p50 float8,
+				  latency_p95 float8,
+				  latency_p99 float8
+				)`,
+	`CREATE TABLE IF NOT EXISTS pgcheetah.group_wait_events (
+				  run_id bigint NOT NULL REFERENCES pgcheetah.runs ON DELETE CASCADE,
+				  client_group text,
+				  server text,
+				  event text,
+				  count bigint
+				)`,
+	// Columns added after first releases of the schema
+	`ALTER TABLE pgcheetah.runs ADD COLUMN IF NOT EXISTS manifest jsonb`,
+	`ALTER TABLE pgcheetah.runs ADD COLUMN IF NOT EXISTS max_replay_lag float8`,
+	`ALTER TABLE pgcheetah.intervals ADD COLUMN IF NOT EXISTS replay_lag float8`,
+	`CREATE INDEX IF NOT EXISTS intervals_run_id_idx ON pgcheetah.intervals (run_id)`,
+	`CREATE INDEX IF NOT EXISTS wait_events_run_id_idx ON pgcheetah.wait_events (run_id)`,
+}
+
+// StoreResults writes results of a run in pgcheetah schema of the results
+// database, the schema is created if missing. It returns the run_id.
+func StoreResults(connStr string, r *Results) (int64, error) {
+
+	var runID int64
+	ctx := context.Background()
+	db, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close(ctx)
+
+	for _, ddl := range resultsSchema {
+		if _, err = db.Exec(ctx, ddl); err != nil {
+			return 0, err
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	m, s := r.Metadata, r.Summary
+	var manifest interface{}
+	if r.Manifest.Version != "" {
+		if manifest, err = json.Marshal(r.Manifest); err != nil {
+			return 0, err
+		}
+	}
+	err = tx.QueryRow(ctx, `INSERT INTO pgcheetah.runs (start_time, end_time, args, query_file, transactions, clients,
+				  target_tps, duration, servers, elapsed, xact, queries, errors, tps, qps, latency_mean, latency_p50,
+				  latency_p95, latency_p99, manifest, max_replay_lag)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+				RETURNING run_id`,
+		s.Start, s.Time, m.Args, m.QueryFile, m.Transactions, m.Clients, m.TargetTPS, m.Duration, m.Servers,
+		s.Elapsed, s.Xact, s.Queries, s.Errors, s.TPS, s.QPS, s.LatencyMean, s.LatencyP50, s.LatencyP95,
+		s.LatencyP99, manifest, s.MaxReplayLag).Scan(&runID)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows [][]interface{}
+	for _, i := range r.Intervals {
+		rows = append(rows, []interface{}{runID, i.Time, i.Elapsed, i.TPS, i.QPS, i.Xact, i.Queries, i.Errors,
+			i.DelayUs, i.ActiveClients, i.LatencyP50, i.LatencyP95, i.LatencyP99, i.ReplayLag})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "intervals"}, []string{"run_id", "time", "elapsed", "tps",
+		"qps", "xact", "queries", "errors", "delay_us", "active_clients", "latency_p50", "latency_p95", "latency_p99",
+		"replay_lag"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, b := range r.Latency {
+		rows = append(rows, []interface{}{runID, b.UpperBound, b.Count})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "latency_histogram"}, []string{"run_id", "upper_bound", "count"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, w := range r.IntervalWaitEvents {
+		rows = append(rows, []interface{}{runID, w.Time, w.Elapsed, w.Server, w.Event, w.Count, false})
+	}
+	for _, w := range r.WaitEvents {
+		rows = append(rows, []interface{}{runID, w.Time, w.Elapsed, w.Server, w.Event, w.Count, true})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "wait_events"}, []string{"run_id", "time", "elapsed",
+		"server", "event", "count", "total"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, p := range r.Phases {
+		rows = append(rows, []interface{}{runID, p.Phase, p.Warmup, p.Start, p.Time, p.Clients, p.TargetTPS, p.Elapsed,
+			p.Xact, p.Queries, p.Errors, p.TPS, p.QPS, p.LatencyMean, p.LatencyP50, p.LatencyP95, p.LatencyP99})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "phases"}, []string{"run_id", "phase", "warmup", "start_time",
+		"end_time", "clients", "target_tps", "elapsed", "xact", "queries", "errors", "tps", "qps", "latency_mean",
+		"latency_p50", "latency_p95", "latency_p99"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, w := range r.PhaseWaitEvents {
+		rows = append(rows, []interface{}{runID, w.Phase, w.Server, w.Event, w.Count})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "phase_wait_events"}, []string{"run_id", "phase", "server",
+		"event", "count"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, g := range r.Groups {
+		rows = append(rows, []interface{}{runID, g.Group, g.Clients, g.TargetTPS, g.Elapsed, g.Xact, g.Queries, g.Errors,
+			g.TPS, g.QPS, g.LatencyMean, g.LatencyP50, g.LatencyP95, g.LatencyP99})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "groups"}, []string{"run_id", "client_group", "clients",
+		"target_tps", "elapsed", "xact", "queries", "errors", "tps", "qps", "latency_mean", "latency_p50", "latency_p95",
+		"latency_p99"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, w := range r.GroupWaitEvents {
+		rows = append(rows, []interface{}{runID, w.Group, w.Server, w.Event, w.Count})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "group_wait_events"}, []string{"run_id", "client_group",
+		"server", "event", "count"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	rows = nil
+	for _, c := range r.XactClasses {
+		rows = append(rows, []interface{}{runID, c.Fingerprint, c.Statements, c.DatasetXacts, c.Count, c.Errors,
+			c.ErrorRate, c.TotalTime, c.Share, c.LatencyMean, c.LatencyP50, c.LatencyP95, c.LatencyP99})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"pgcheetah", "xact_classes"}, []string{"run_id", "fingerprint", "statements",
+		"dataset_xacts", "count", "errors", "error_rate", "total_time", "share", "latency_mean", "latency_p50",
+		"latency_p95", "latency_p99"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range r.Errors {
+		_, err = tx.Exec(ctx, `INSERT INTO pgcheetah.errors VALUES ($1, $2, $3, $4)`, runID, e.SQLState, e.Count, e.Message)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, a := range r.Assertions {
+		_, err = tx.Exec(ctx, `INSERT INTO pgcheetah.assertions VALUES ($1, $2, $3, $4, $5)`, runID, a.Name, a.Limit, a.Value, a.Passed)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, st := range r.ServerStats {
+		_, err = tx.Exec(ctx, `INSERT INTO pgcheetah.server_stats VALUES ($1, $2, $3, $4)`, runID, st.Server, st.Name, st.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return runID, tx.Commit(ctx)
+}
@@ -0,0 +1,87 @@
+package pgcheetah
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStoreResults(t *testing.T) {
+
+	now := time.Now()
+	event := WaitEventStat{Time: now, Elapsed: 1, Server: "primary", Event: "Lock:tuple", Count: 2}
+	r := &Results{
+		Metadata:           Metadata{Time: now, Args: []string{"-clients", "2"}, QueryFile: "queries.sql", Clients: 2, Servers: []string{"primary"}},
+		Intervals:          []IntervalStats{{Time: now, Elapsed: 1, Xact: 10, Queries: 20}, {Time: now, Elapsed: 2, Xact: 12, Queries: 24}},
+		IntervalWaitEvents: []WaitEventStat{event},
+		Summary:            Summary{Time: now, Start: now.Add(-2 * time.Second), Clients: 2, Elapsed: 2, Xact: 22, Queries: 44},
+		WaitEvents:         []WaitEventStat{event, {Time: now, Server: "primary", Event: "CPU", Count: 5}},
+		Errors:             []ErrorStat{{Time: now, SQLState: "40P01", Count: 1, Message: "deadlock detected"}},
+		ServerStats:        []ServerStat{{Time: now, Server: "primary", Name: "xact_commit", Value: 22}},
+		Latency:            []LatencyBucket{{UpperBound: 1, Count: 20}, {UpperBound: 2, Count: 2}},
+		XactClasses:        []XactClassStat{{Time: now, Fingerprint: "abc", Statements: "SELECT 1;", DatasetXacts: 1, Count: 22}},
+		Assertions:         []AssertionResult{{Time: now, Name: "min_tps", Limit: 5, Value: 11, Passed: true}},
+		Phases:             []PhaseStats{{Time: now, Start: now, Phase: "steady", Clients: 2, Xact: 22}},
+		PhaseWaitEvents:    []WaitEventStat{{Time: now, Server: "primary", Event: "CPU", Count: 5, Phase: "steady"}},
+		Groups:             []GroupStats{{Time: now, Group: "readers", Clients: 2, Xact: 22}},
+		GroupWaitEvents:    []WaitEventStat{{Time: now, Server: "primary", Event: "CPU", Count: 5, Group: "readers"}},
+	}
+
+	// Storing twice creates the schema on an existing one
+	var runID int64
+	for i := 0; i < 2; i++ {
+		id, err := StoreResults(*connStr, r)
+		if err != nil {
+			t.Fatal("Test TestStoreResults #", i, "Error during results storage ", err)
+		}
+		if id <= runID {
+			t.Error("Test TestStoreResults #", i, "Expected a new run id got ", id)
+		}
+		runID = id
+	}
+
+	db, err := Connect(*connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+
+	var tests = []struct {
+		table    string
+		expected int
+	}{
+		{"runs", 1},
+		{"intervals", 2},
+		{"latency_histogram", 2},
+		{"wait_events", 3},
+		{"errors", 1},
+		{"server_stats", 1},
+		{"xact_classes", 1},
+		{"assertions", 1},
+		{"phases", 1},
+		{"phase_wait_events", 1},
+		{"groups", 1},
+		{"group_wait_events", 1},
+	}
+	for i, test := range tests {
+		var count int
+		if err = db.QueryRow(context.Background(), "SELECT count(*) FROM pgcheetah."+test.table+" WHERE run_id = $1",
+			runID).Scan(&count); err != nil {
+			t.Fatal("Test TestStoreResults #", i, "Error during count of ", test.table, " ", err)
+		}
+		if count != test.expected {
+			t.Error("Test TestStoreResults #", i, "Expected ", test.expected, " rows in ", test.table, " got ", count)
+		}
+	}
+
+	// Columns added after the first schema version
+	var columns int
+	if err = db.QueryRow(context.Background(), `SELECT count(*) FROM information_schema.columns
+		WHERE table_schema = 'pgcheetah' AND (table_name, column_name) IN
+		(('runs', 'manifest'), ('runs', 'max_replay_lag'), ('intervals', 'replay_lag'))`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 3 {
+		t.Error("Test TestStoreResults Expected 3 added columns got ", columns)
+	}
+}
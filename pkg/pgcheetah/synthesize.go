@@ -0,0 +1,403 @@
+package pgcheetah
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadStatementsCSV reads a pg_stat_statements snapshot saved as CSV with a
+// header, for example with:
+// \copy (SELECT * FROM pg_stat_statements) TO 'pgss.csv' CSV HEADER
+// Only query and calls columns are read.
+func LoadStatementsCSV(path string) ([]StatementStats, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	queryCol, callsCol := -1, -1
+	for i, name := range header {
+		switch name {
+		case "query":
+			queryCol = i
+		case "calls":
+			callsCol = i
+		}
+	}
+	if queryCol == -1 || callsCol == -1 {
+		return nil, fmt.Errorf("%s must have query and calls columns", path)
+	}
+
+	var stmts []StatementStats
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		calls, err := strconv.ParseInt(record[callsCol], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calls %q", record[callsCol])
+		}
+		stmts = append(stmts, StatementStats{Query: record[queryCol], Calls: calls})
+	}
+	return mergeStatements(stmts), nil
+}
+
+// LoadStatementsTable reads a pg_stat_statements snapshot saved in a table,
+// for example with CREATE TABLE pgss AS SELECT * FROM pg_stat_statements.
+func LoadStatementsTable(db *pgx.Conn, table string) ([]StatementStats, error) {
+
+	rows, err := db.Query(context.Background(), `SELECT query, calls::bigint FROM `+
+		pgx.Identifier(strings.Split(table, ".")).Sanitize()+` WHERE query IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stmts []StatementStats
+	for rows.Next() {
+		var st StatementStats
+		if err = rows.Scan(&st.Query, &st.Calls); err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, st)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return mergeStatements(stmts), nil
+}
+
+// mergeStatements sums calls of identical queries, pg_stat_statements has
+// an entry by user and database. Statements are sorted by calls.
+func mergeStatements(stmts []StatementStats) []StatementStats {
+
+	var merged []StatementStats
+	index := make(map[string]int)
+	for _, st := range stmts {
+		if i, ok := index[st.Query]; ok {
+			merged[i].Calls += st.Calls
+			continue
+		}
+		index[st.Query] = len(merged)
+		merged = append(merged, st)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Calls > merged[j].Calls })
+	return merged
+}
+
+// ColumnRef is the table column a query parameter is compared to or
+// inserted in.
+type ColumnRef struct {
+	Table  string
+	Column string
+}
+
+// Words following a table name which are not an alias
+var notAlias = map[string]bool{"where": true, "join": true, "left": true, "right": true, "inner": true,
+	"outer": true, "full": true, "cross": true, "natural": true, "on": true, "using": true, "set": true,
+	"group": true, "order": true, "limit": true, "offset": true, "returning": true, "values": true, "union": true,
+	"having": true, "window": true, "for": true, "select": true, "default": true, "tablesample": true}
+
+var (
+	tableRef        = regexp.MustCompile(`(?i)\b(?:from|join|update|into)\s+((?:\w+\.)?\w+)(?:\s+(?:as\s+)?(\w+))?`)
+	paramCompare    = regexp.MustCompile(`(?i)((?:\w+\.)?\w+)\s*(?:=|<>|!=|<=|>=|<|>|\blike\b|\bilike\b)\s*\$(\d+)`)
+	paramCompareRev = regexp.MustCompile(`\$(\d+)\s*(?:=|<>|!=|<=|>=|<|>)\s*((?:\w+\.)?\w+)`)
+	paramIn         = regexp.MustCompile(`(?i)((?:\w+\.)?\w+)\s+in\s*\(([^()]*)\)`)
+	paramBetween    = regexp.MustCompile(`(?i)((?:\w+\.)?\w+)\s+between\s+\$(\d+)\s+and\s+\$(\d+)`)
+	paramInsert     = regexp.MustCompile(`(?i)insert\s+into\s+((?:\w+\.)?\w+)\s*\(([^()]*)\)\s*values\s*(\(.*)`)
+	paramLimit      = regexp.MustCompile(`(?i)\b(limit|offset)\s+\$(\d+)`)
+	param           = regexp.MustCompile(`\$(\d+)`)
+	insertValue     = regexp.MustCompile(`^\$(\d+)(?:::\w+)?$`)
+	sqlComment      = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	spaces          = regexp.MustCompile(`\s+`)
+)
+
+// Default values of LIMIT and OFFSET parameters, they are not related to
+// a column
+var limitValues = map[string]string{"limit": "10", "offset": "0"}
+
+// Synthesizer builds dataset statements from normalized queries of
+// pg_stat_statements, filling their $n parameters with real values sampled
+// from the columns they refer to. It does not use a real parser, but
+// several regex: parameters which can not be related to a column make the
+// query skipped.
+type Synthesizer struct {
+	HasColumn func(table string, column string) (bool, error) // Tells whether a table has a column
+	Sample    func(ref ColumnRef, n int) ([]string, error)    // Returns up to n values of a column
+	samples   map[ColumnRef][]string
+}
+
+// NewSynthesizer returns a Synthesizer sampling values in database db.
+func NewSynthesizer(db *pgx.Conn) *Synthesizer {
+
+	ctx := context.Background()
+	hasColumn := func(table string, column string) (bool, error) {
+		var found bool
+		err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1)
+				  AND attname = $2 AND attnum > 0 AND NOT attisdropped)`, table, column).Scan(&found)
+		return found, err
+	}
+	sample := func(ref ColumnRef, n int) ([]string, error) {
+		col := pgx.Identifier{ref.Column}.Sanitize()
+		from := pgx.Identifier(strings.Split(ref.Table, ".")).Sanitize()
+		// Sample 1% of blocks of large tables, read small tables entirely
+		values, err := queryValues(db, fmt.Sprintf(`SELECT DISTINCT %s::text FROM %s TABLESAMPLE SYSTEM (1)
+				  WHERE %s IS NOT NULL LIMIT %d`, col, from, col, n))
+		if err != nil || len(values) > 0 {
+			return values, err
+		}
+		return queryValues(db, fmt.Sprintf(`SELECT DISTINCT %s::text FROM %s WHERE %s IS NOT NULL LIMIT %d`,
+			col, from, col, n))
+	}
+	return &Synthesizer{HasColumn: hasColumn, Sample: sample}
+}
+
+// queryValues returns the first column of rows of a query.
+func queryValues(db *pgx.Conn, sql string) ([]string, error) {
+
+	rows, err := db.Query(context.Background(), sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// NormalizeStatement returns a query on one line ending with a semicolon,
+// without comments, as expected by ParseXact. Only SELECT, INSERT, UPDATE,
+// DELETE and WITH queries are kept, transaction control and utility
+// statements return an error.
+func NormalizeStatement(query string) (string, error) {
+
+	q := strings.TrimSpace(spaces.ReplaceAllString(sqlComment.ReplaceAllString(query, " "), " "))
+	q = strings.TrimSpace(strings.TrimRight(q, "; "))
+	kind := strings.ToLower(strings.SplitN(q, " ", 2)[0])
+	switch kind {
+	case "select", "insert", "update", "delete", "with":
+		return q + ";", nil
+	}
+	return "", fmt.Errorf("%s statement is not synthesized", strings.ToUpper(kind))
+}
+
+// ParamColumns relates parameters of a normalized query to the column they
+// are compared to or inserted in. Parameters of LIMIT and OFFSET are
+// returned in fixed with their default value.
+func (s *Synthesizer) ParamColumns(query string) (map[int]ColumnRef, map[int]string, error) {
+
+	// Tables of the query by alias, a table is its own alias
+	tables := make(map[string]string)
+	var names []string
+	for _, m := range tableRef.FindAllStringSubmatch(query, -1) {
+		table := strings.ToLower(m[1])
+		if _, ok := tables[table]; !ok {
+			names = append(names, table)
+		}
+		tables[table] = table
+		if alias := strings.ToLower(m[2]); alias != "" && !notAlias[alias] {
+			tables[alias] = table
+		}
+	}
+
+	refs := make(map[int]ColumnRef)
+	var err error
+	relate := func(column string, n string) {
+		i, _ := strconv.Atoi(n)
+		if _, ok := refs[i]; ok || err != nil {
+			return
+		}
+		var ref ColumnRef
+		var found bool
+		if ref, found, err = s.resolve(strings.ToLower(column), tables, names); found {
+			refs[i] = ref
+		}
+	}
+
+	for _, m := range paramInsert.FindAllStringSubmatch(query, -1) {
+		table := strings.ToLower(m[1])
+		columns := strings.Split(m[2], ",")
+		for _, t := range valuesTuples(m[3]) {
+			for j, v := range t {
+				if p := insertValue.FindStringSubmatch(v); p != nil && j < len(columns) {
+					relate(table+"."+strings.TrimSpace(columns[j]), p[1])
+				}
+			}
+		}
+	}
+	for _, m := range paramBetween.FindAllStringSubmatch(query, -1) {
+		relate(m[1], m[2])
+		relate(m[1], m[3])
+	}
+	for _, m := range paramIn.FindAllStringSubmatch(query, -1) {
+		for _, p := range param.FindAllStringSubmatch(m[2], -1) {
+			relate(m[1], p[1])
+		}
+	}
+	for _, m := range paramCompare.FindAllStringSubmatch(query, -1) {
+		relate(m[1], m[2])
+	}
+	for _, m := range paramCompareRev.FindAllStringSubmatch(query, -1) {
+		relate(m[2], m[1])
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fixed := make(map[int]string)
+	for _, m := range paramLimit.FindAllStringSubmatch(query, -1) {
+		i, _ := strconv.Atoi(m[2])
+		fixed[i] = limitValues[strings.ToLower(m[1])]
+	}
+	return refs, fixed, nil
+}
+
+// valuesTuples splits rows of a VALUES list in their values, it stops at
+// the end of the list.
+func valuesTuples(list string) [][]string {
+
+	var tuples [][]string
+	var values []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch {
+		case c == '(':
+			depth++
+			if depth == 1 {
+				values, start = nil, i+1
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				tuples = append(tuples, append(values, strings.TrimSpace(list[start:i])))
+			}
+		case c == ',' && depth == 1:
+			values = append(values, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		case depth == 0 && c != ',' && c != ' ':
+			return tuples
+		}
+	}
+	return tuples
+}
+
+// resolve returns the table column of a column reference, qualified or not.
+// An unqualified column belongs to the only table of the query having it.
+func (s *Synthesizer) resolve(column string, tables map[string]string, names []string) (ColumnRef, bool, error) {
+
+	if i := strings.LastIndex(column, "."); i != -1 {
+		qualifier, name := column[:i], column[i+1:]
+		if table, ok := tables[qualifier]; ok {
+			return ColumnRef{Table: table, Column: name}, true, nil
+		}
+		return ColumnRef{}, false, nil
+	}
+	if len(names) == 1 {
+		return ColumnRef{Table: names[0], Column: column}, true, nil
+	}
+	var refs []ColumnRef
+	for _, table := range names {
+		found, err := s.HasColumn(table, column)
+		if err != nil {
+			return ColumnRef{}, false, err
+		}
+		if found {
+			refs = append(refs, ColumnRef{Table: table, Column: column})
+		}
+	}
+	if len(refs) != 1 {
+		return ColumnRef{}, false, nil
+	}
+	return refs[0], true, nil
+}
+
+// Statements returns n statements of a normalized query with parameters
+// filled with values sampled from their column. An error tells why the query
+// can not be synthesized.
+func (s *Synthesizer) Statements(query string, n int, r *rand.Rand) ([]string, error) {
+
+	q, err := NormalizeStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	refs, fixed, err := s.ParamColumns(q)
+	if err != nil {
+		return nil, err
+	}
+	if s.samples == nil {
+		s.samples = make(map[ColumnRef][]string)
+	}
+
+	values := make(map[int][]string)
+	for _, m := range param.FindAllStringSubmatch(q, -1) {
+		i, _ := strconv.Atoi(m[1])
+		if _, ok := values[i]; ok {
+			continue
+		}
+		if v, ok := fixed[i]; ok {
+			values[i] = []string{v}
+			continue
+		}
+		ref, ok := refs[i]
+		if !ok {
+			return nil, fmt.Errorf("parameter $%d is not related to a column", i)
+		}
+		if _, ok = s.samples[ref]; !ok {
+			if s.samples[ref], err = s.Sample(ref, n); err != nil {
+				return nil, fmt.Errorf("sampling of %s.%s: %s", ref.Table, ref.Column, err)
+			}
+		}
+		if len(s.samples[ref]) == 0 {
+			return nil, fmt.Errorf("no value found in %s.%s", ref.Table, ref.Column)
+		}
+		values[i] = s.samples[ref]
+	}
+
+	stmts := make([]string, n)
+	for j := range stmts {
+		stmts[j] = param.ReplaceAllStringFunc(q, func(p string) string {
+			i, _ := strconv.Atoi(p[1:])
+			return QuoteLiteral(values[i][r.Intn(len(values[i]))])
+		})
+	}
+	return stmts, nil
+}
+
+// Escapes of E'...' string literals, a statement must stay on one line for
+// the dataset parser
+var escapeLiteral = strings.NewReplacer(`\`, `\\`, "'", "''", "\n", `\n`, "\r", `\r`)
+
+// QuoteLiteral returns v as a SQL string literal, postgres casts it to the
+// type of the column. Values with line breaks are escaped in an E'...' literal.
+func QuoteLiteral(v string) string {
+	if strings.ContainsAny(v, "\n\r") {
+		return "E'" + escapeLiteral.Replace(v) + "'"
+	}
+	return "'" + strings.Replace(v, "'", "''", -1) + "'"
+}
@@ -0,0 +1,150 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadStatementsCSV(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "pgss*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("userid,query,calls\n10,\"SELECT *\nFROM t WHERE id = $1\",5\n10,BEGIN,8\n11,\"SELECT *\nFROM t WHERE id = $1\",4\n")
+	f.Close()
+
+	stmts, err := LoadStatementsCSV(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StatementStats{{Query: "SELECT *\nFROM t WHERE id = $1", Calls: 9}, {Query: "BEGIN", Calls: 8}}
+	if !reflect.DeepEqual(stmts, expected) {
+		t.Error("Test TestLoadStatementsCSV Expected ", expected, " got ", stmts)
+	}
+}
+
+func TestNormalizeStatement(t *testing.T) {
+
+	var tests = []struct {
+		in       string
+		expected string
+		err      bool
+	}{
+		{"SELECT *\n  FROM t -- comment\n WHERE id = $1", "SELECT * FROM t WHERE id = $1;", false},
+		{"/* app */ UPDATE t SET x = $1 WHERE id = $2;", "UPDATE t SET x = $1 WHERE id = $2;", false},
+		{"with a as (select 1) select * from a", "with a as (select 1) select * from a;", false},
+		{"BEGIN", "", true},
+		{"VACUUM t", "", true},
+	}
+
+	for i, test := range tests {
+		v, err := NormalizeStatement(test.in)
+		if v != test.expected || (err != nil) != test.err {
+			t.Error("Test TestNormalizeStatement #", i, "Expected ", test.expected, " got ", v, err)
+		}
+	}
+}
+
+func TestParamColumns(t *testing.T) {
+
+	columns := map[string]bool{"orders.customer_id": true, "orders.status": true, "customers.name": true}
+	s := &Synthesizer{HasColumn: func(table string, column string) (bool, error) {
+		return columns[table+"."+column], nil
+	}}
+
+	var tests = []struct {
+		query string
+		refs  map[int]ColumnRef
+		fixed map[int]string
+	}{
+		{"SELECT * FROM t WHERE id = $1 LIMIT $2;", map[int]ColumnRef{1: {"t", "id"}}, map[int]string{2: "10"}},
+		{"SELECT * FROM public.t AS a WHERE $1 < a.x AND a.y BETWEEN $2 AND $3;",
+			map[int]ColumnRef{1: {"public.t", "x"}, 2: {"public.t", "y"}, 3: {"public.t", "y"}}, map[int]string{}},
+		{"SELECT * FROM t WHERE id IN ($1, $2) OFFSET $3;", map[int]ColumnRef{1: {"t", "id"}, 2: {"t", "id"}},
+			map[int]string{3: "0"}},
+		{"INSERT INTO t (a, b) VALUES ($1, now()), ($2, $3);",
+			map[int]ColumnRef{1: {"t", "a"}, 2: {"t", "a"}, 3: {"t", "b"}}, map[int]string{}},
+		{"UPDATE t SET x = $1 WHERE id = $2;", map[int]ColumnRef{1: {"t", "x"}, 2: {"t", "id"}}, map[int]string{}},
+		{"SELECT * FROM orders o JOIN customers c ON c.id = o.customer_id WHERE status = $1 AND name LIKE $2;",
+			map[int]ColumnRef{1: {"orders", "status"}, 2: {"customers", "name"}}, map[int]string{}},
+		{"SELECT * FROM orders o JOIN customers c ON c.id = o.customer_id WHERE id = $1 AND lower(name) = $2;",
+			map[int]ColumnRef{}, map[int]string{}},
+	}
+
+	for i, test := range tests {
+		refs, fixed, err := s.ParamColumns(test.query)
+		if err != nil || !reflect.DeepEqual(refs, test.refs) || !reflect.DeepEqual(fixed, test.fixed) {
+			t.Error("Test TestParamColumns #", i, "Expected ", test.refs, test.fixed, " got ", refs, fixed, err)
+		}
+	}
+}
+
+func TestSynthesizerStatements(t *testing.T) {
+
+	sampled := 0
+	s := &Synthesizer{
+		HasColumn: func(table string, column string) (bool, error) { return false, nil },
+		Sample: func(ref ColumnRef, n int) ([]string, error) {
+			sampled++
+			if ref.Column == "empty" {
+				return nil, nil
+			}
+			if ref.Column == "broken" {
+				return nil, fmt.Errorf("permission denied")
+			}
+			return []string{"it's", "b"}, nil
+		},
+	}
+	r := rand.New(rand.NewSource(1))
+
+	stmts, err := s.Statements("SELECT * FROM t\nWHERE name = $1 LIMIT $2", 4, r)
+	if err != nil || len(stmts) != 4 {
+		t.Fatal("Test TestSynthesizerStatements Expected 4 statements got ", stmts, err)
+	}
+	for i, st := range stmts {
+		if st != "SELECT * FROM t WHERE name = 'it''s' LIMIT '10';" && st != "SELECT * FROM t WHERE name = 'b' LIMIT '10';" {
+			t.Error("Test TestSynthesizerStatements #", i, "Unexpected statement ", st)
+		}
+	}
+	if _, err = s.Statements("SELECT name FROM t WHERE name <> $1", 2, r); err != nil || sampled != 1 {
+		t.Error("Test TestSynthesizerStatements Expected samples of a column read once got ", sampled, err)
+	}
+
+	var errors = []string{
+		"BEGIN",
+		"SELECT * FROM t WHERE lower(name) = $1",
+		"SELECT * FROM t WHERE empty = $1",
+		"SELECT * FROM t WHERE broken = $1",
+	}
+	for i, query := range errors {
+		if _, err = s.Statements(query, 2, r); err == nil {
+			t.Error("Test TestSynthesizerStatements #", i, "Expected an error for ", query)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+
+	var tests = []struct {
+		in       string
+		expected string
+	}{
+		{"it's", "'it''s'"},
+		{`a\b`, `'a\b'`},
+		{"a;\nb", `E'a;\nb'`},
+		{"it's\r\n\\", `E'it''s\r\n\\'`},
+	}
+	for i, test := range tests {
+		v := QuoteLiteral(test.in)
+		if v != test.expected || strings.ContainsAny(v, "\n\r") {
+			t.Error("Test TestQuoteLiteral #", i, "Expected ", test.expected, " got ", v)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package pgcheetah
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders of templated statements, like {{int 1 100}}
+var placeholderRe = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// Generators of placeholders, other names are variables
+var generators = map[string]bool{"int": true, "pick": true, "uuid": true, "now-offset": true, "zipf": true}
+
+// Time format of now-offset values, read by postgres as timestamptz
+const nowOffsetFormat = "2006-01-02 15:04:05.999999-07:00"
+
+// placeholder is a generator of a templated statement, or a reference to
+// a variable when gen is empty.
+type placeholder struct {
+	gen      string
+	as       string   // Variable set to the generated value, optional
+	name     string   // Referenced variable
+	min, max int64    // int bounds
+	values   []string // pick and zipf values
+	offsets  [2]int64 // now-offset bounds in ns
+	exponent float64  // zipf exponent
+	size     uint64   // zipf values count
+}
+
+// Template is a dataset statement with placeholders filled at each
+// execution:
+//   - {{int a b}}: integer between a and b
+//   - {{pick v1 v2...}} or {{pick file=path}}: one of the values, or one of
+//     the lines of a file
+//   - {{uuid}}: random version 4 UUID
+//   - {{now-offset [min] max}}: current time minus a random duration between
+//     min and max, e.g. 1h
+//   - {{zipf n s}} or {{zipf file=path s}}: integer between 1 and n, or line
+//     of a file, following a zipf distribution of exponent s, first values
+//     are the most frequent
+//
+// Only statements following a "-- pgcheetah: template" annotation are
+// templates, {{...}} of other statements is literal text.
+//
+// Values are inserted as is, quotes are part of the statement. A generator
+// followed by as=name stores its value in a variable of the transaction,
+// {{name}} inserts it again in the same or later statements.
+//
+// A "-- pgcheetah: capture=name" annotation stores column name of the first
+// row returned by the statement in a variable, like an id returned by
+// INSERT ... RETURNING id. capture=var:column stores column in var, several
+// captures are separated by commas. They are variables of the transaction,
+// or of the client with scope=session.
+type Template struct {
+	parts        []string // Text around placeholders
+	placeholders []*placeholder
+	captures     []capture
+	session      bool // Captures are kept for next transactions of the client
+}
+
+// capture is a column of the first row stored in a variable.
+type capture struct {
+	name   string
+	column string
+}
+
+// Templates contains templated statements by transaction and statement
+// index, statements without placeholder or capture are missing.
+type Templates map[int]map[int]*Template
+
+// Get returns the template of a statement, nil when the statement has no
+// placeholder or capture.
+func (t Templates) Get(xact int, i int) *Template {
+	return t[xact][i]
+}
+
+// ParseTemplates finds statements of a dataset annotated as template and
+// captures of its annotations, files of pick and zipf generators are relative to dir.
+// A variable must be set before it is referenced in the transaction, or
+// captured with session scope by any transaction.
+func ParseTemplates(dataset map[int][]string, ann Annotations, dir string) (Templates, error) {
+
+	sessionVars := make(map[string]bool)
+	for xact, stmts := range ann {
+		for i, a := range stmts {
+			captures, session, err := parseCaptures(a)
+			if err != nil {
+				return nil, fmt.Errorf("transaction %d statement %d: %s", xact, i+1, err)
+			}
+			for _, c := range captures {
+				sessionVars[c.name] = sessionVars[c.name] || session
+			}
+		}
+	}
+
+	templates := make(Templates)
+	files := make(map[string][]string)
+	for xact, stmts := range dataset {
+		vars := make(map[string]bool)
+		for name, session := range sessionVars {
+			vars[name] = session
+		}
+		for i, stmt := range stmts {
+			captures, session, _ := parseCaptures(ann[xact][i])
+			_, templated := ann[xact][i]["template"]
+			if !templated && captures == nil {
+				continue
+			}
+			t := &Template{parts: []string{stmt}}
+			if templated {
+				var err error
+				if t, err = parseTemplate(stmt, dir, files, vars); err != nil {
+					return nil, fmt.Errorf("transaction %d statement %d: %s", xact, i+1, err)
+				}
+			}
+			t.captures, t.session = captures, session
+			for _, c := range captures {
+				vars[c.name] = true
+			}
+			if templates[xact] == nil {
+				templates[xact] = make(map[int]*Template)
+			}
+			templates[xact][i] = t
+		}
+	}
+	return templates, nil
+}
+
+// parseCaptures reads capture and scope settings of an annotation, nil
+// when it has no capture.
+func parseCaptures(a Annotation) ([]capture, bool, error) {
+
+	v, ok := a["capture"]
+	if !ok {
+		return nil, false, nil
+	}
+	var captures []capture
+	for _, c := range strings.Split(v, ",") {
+		kv := strings.SplitN(c, ":", 2)
+		cpt := capture{name: kv[0], column: kv[0]}
+		if len(kv) == 2 {
+			cpt.column = kv[1]
+		}
+		if cpt.name == "" || cpt.column == "" {
+			return nil, false, fmt.Errorf("invalid capture %q, expected name or name:column", c)
+		}
+		if generators[cpt.name] {
+			return nil, false, fmt.Errorf("variable %s is a generator name", cpt.name)
+		}
+		captures = append(captures, cpt)
+	}
+	switch a["scope"] {
+	case "", "transaction":
+		return captures, false, nil
+	case "session":
+		return captures, true, nil
+	}
+	return nil, false, fmt.Errorf("invalid scope %q, expected transaction or session", a["scope"])
+}
+
+// parseTemplate parses placeholders of a statement. files caches values
+// of files, vars contains variables already set in the transaction.
+func parseTemplate(stmt string, dir string, files map[string][]string, vars map[string]bool) (*Template, error) {
+
+	t := &Template{}
+	last := 0
+	for _, loc := range placeholderRe.FindAllStringSubmatchIndex(stmt, -1) {
+		t.parts = append(t.parts, stmt[last:loc[0]])
+		last = loc[1]
+		p, err := parsePlaceholder(strings.Fields(stmt[loc[2]:loc[3]]), dir, files)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", stmt[loc[0]:loc[1]], err)
+		}
+		if p.gen == "" && !vars[p.name] {
+			return nil, fmt.Errorf("variable %s is not set", p.name)
+		}
+		if p.as != "" {
+			vars[p.as] = true
+		}
+		t.placeholders = append(t.placeholders, p)
+	}
+	t.parts = append(t.parts, stmt[last:])
+	return t, nil
+}
+
+// parsePlaceholder parses fields of a placeholder.
+func parsePlaceholder(fields []string, dir string, files map[string][]string) (*placeholder, error) {
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty placeholder")
+	}
+	p := &placeholder{gen: fields[0]}
+	var args []string
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "as=") {
+			p.as = f[len("as="):]
+			continue
+		}
+		args = append(args, f)
+	}
+
+	var err error
+	switch p.gen {
+	case "int":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected {{int min max}}")
+		}
+		if p.min, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			return nil, err
+		}
+		if p.max, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return nil, err
+		}
+		if p.min > p.max {
+			return nil, fmt.Errorf("min is greater than max")
+		}
+	case "pick":
+		if len(args) == 1 && strings.HasPrefix(args[0], "file=") {
+			p.values, err = readValues(args[0][len("file="):], dir, files)
+		} else {
+			p.values = args
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(p.values) == 0 {
+			return nil, fmt.Errorf("no value to pick")
+		}
+	case "uuid":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("expected {{uuid}}")
+		}
+	case "now-offset":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("expected {{now-offset [min] max}}")
+		}
+		for i, a := range args {
+			d, err := time.ParseDuration(a)
+			if err != nil {
+				return nil, err
+			}
+			if d < 0 {
+				return nil, fmt.Errorf("offset %s must not be negative", a)
+			}
+			p.offsets[i+2-len(args)] = int64(d)
+		}
+		if p.offsets[0] > p.offsets[1] {
+			return nil, fmt.Errorf("min is greater than max")
+		}
+	case "zipf":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected {{zipf n s}} or {{zipf file=path s}}")
+		}
+		if n, err := strconv.ParseUint(args[0], 10, 64); err == nil {
+			p.size = n
+		} else {
+			if p.values, err = readValues(strings.TrimPrefix(args[0], "file="), dir, files); err != nil {
+				return nil, err
+			}
+			p.size = uint64(len(p.values))
+		}
+		if p.size == 0 {
+			return nil, fmt.Errorf("no value to pick")
+		}
+		// rand.Zipf requires an exponent greater than 1
+		if p.exponent, err = strconv.ParseFloat(args[1], 64); err != nil || p.exponent <= 1 {
+			return nil, fmt.Errorf("exponent %q must be greater than 1", args[1])
+		}
+	default:
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("unknown generator %s", p.gen)
+		}
+		p.gen, p.name = "", fields[0]
+	}
+	if p.as != "" && p.gen == "" {
+		return nil, fmt.Errorf("a variable can not set another one")
+	}
+	if generators[p.as] {
+		return nil, fmt.Errorf("variable %s is a generator name", p.as)
+	}
+	return p, nil
+}
+
+// readValues returns non empty lines of a file, path is relative to dir.
+func readValues(path string, dir string, files map[string][]string) ([]string, error) {
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if values, ok := files[path]; ok {
+		return values, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, l := range strings.Split(string(content), "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			values = append(values, l)
+		}
+	}
+	files[path] = values
+	return values, nil
+}
+
+// Generator fills templates of a client with its random source.
+type Generator struct {
+	r     *rand.Rand
+	zipfs map[*placeholder]*rand.Zipf
+}
+
+// NewGenerator returns a generator drawing values from r.
+func NewGenerator(r *rand.Rand) *Generator {
+	return &Generator{r: r, zipfs: make(map[*placeholder]*rand.Zipf)}
+}
+
+// Variables holds values of template variables of a client. Variables of
+// the transaction are cleared by Reset, session variables are kept.
+type Variables struct {
+	xact    map[string]string
+	session map[string]string
+}
+
+// NewVariables returns empty variables.
+func NewVariables() *Variables {
+	return &Variables{xact: make(map[string]string), session: make(map[string]string)}
+}
+
+// Reset clears variables of the transaction, at the start of a new one.
+func (v *Variables) Reset() {
+	for k := range v.xact {
+		delete(v.xact, k)
+	}
+}
+
+// Set sets a variable of the transaction, or of the session.
+func (v *Variables) Set(name string, value string, session bool) {
+	if session {
+		v.session[name] = value
+		return
+	}
+	v.xact[name] = value
+}
+
+// Get returns the value of a variable, a variable of the transaction hides
+// a session variable of the same name.
+func (v *Variables) Get(name string) (string, bool) {
+	if value, ok := v.xact[name]; ok {
+		return value, true
+	}
+	value, ok := v.session[name]
+	return value, ok
+}
+
+// Unset removes a variable.
+func (v *Variables) Unset(name string) {
+	delete(v.xact, name)
+	delete(v.session, name)
+}
+
+// Fill returns the statement with generated values. It fails when a
+// referenced variable is not set, for example when the statement capturing
+// it returned no row.
+func (t *Template) Fill(g *Generator, vars *Variables) (string, error) {
+
+	var b strings.Builder
+	for i, p := range t.placeholders {
+		b.WriteString(t.parts[i])
+		var v string
+		if p.gen == "" {
+			var ok bool
+			if v, ok = vars.Get(p.name); !ok {
+				return "", fmt.Errorf("variable %s is not set", p.name)
+			}
+		} else {
+			v = g.value(p)
+		}
+		if p.as != "" {
+			vars.Set(p.as, v, false)
+		}
+		b.WriteString(v)
+	}
+	b.WriteString(t.parts[len(t.parts)-1])
+	return b.String(), nil
+}
+
+// Capturing tells whether the statement captures columns of its result.
+func (t *Template) Capturing() bool {
+	return len(t.captures) > 0
+}
+
+// Query runs a capturing statement and stores captured columns of the
+// first row. Captured variables are unset when there is no row or when
+// the column is NULL. Values are in text format of the simple protocol.
+func (t *Template) Query(db *pgx.Conn, stmt string, vars *Variables) error {
+
+	for _, c := range t.captures {
+		vars.Unset(c.name)
+	}
+	rows, err := db.Query(context.Background(), stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	first := true
+	for rows.Next() {
+		if !first {
+			continue
+		}
+		first = false
+		values := rows.RawValues()
+		for j, f := range rows.FieldDescriptions() {
+			for _, c := range t.captures {
+				if string(f.Name) == c.column && values[j] != nil {
+					vars.Set(c.name, string(values[j]), t.session)
+				}
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// uniform returns an integer in [0, n) without modulo bias, n = 0 stands
+// for the whole uint64 range.
+func (g *Generator) uniform(n uint64) uint64 {
+	if n == 0 {
+		return g.r.Uint64()
+	}
+	// Values below 2^64 mod n would make small results more frequent
+	threshold := -n % n
+	for {
+		if v := g.r.Uint64(); v >= threshold {
+			return v % n
+		}
+	}
+}
+
+// value generates the value of a placeholder.
+func (g *Generator) value(p *placeholder) string {
+
+	switch p.gen {
+	case "int":
+		// Computed on uint64, the range of int64 bounds can exceed int64
+		return strconv.FormatInt(int64(uint64(p.min)+g.uniform(uint64(p.max-p.min)+1)), 10)
+	case "pick":
+		return p.values[g.r.Intn(len(p.values))]
+	case "uuid":
+		var u [16]byte
+		g.r.Read(u[:])
+		u[6] = u[6]&0x0f | 0x40 // Version 4
+		u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+	case "now-offset":
+		offset := p.offsets[0] + int64(g.uniform(uint64(p.offsets[1]-p.offsets[0])+1))
+		return time.Now().Add(-time.Duration(offset)).Format(nowOffsetFormat)
+	case "zipf":
+		z, ok := g.zipfs[p]
+		if !ok {
+			z = rand.NewZipf(g.r, p.exponent, 1, p.size-1)
+			g.zipfs[p] = z
+		}
+		k := z.Uint64()
+		if p.values != nil {
+			return p.values[k]
+		}
+		return strconv.FormatUint(k+1, 10)
+	}
+	return ""
+}
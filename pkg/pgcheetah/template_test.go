@@ -0,0 +1,254 @@
+package pgcheetah
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTemplates(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pgcheetah")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "ids.txt"), []byte("7\n\n8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dataset := map[int][]string{0: {""}, 1: {"BEGIN;", "SELECT {{int 1 10 as=id}};", "SELECT {{ id }}, {{pick file=ids.txt}};", "COMMIT;"}}
+	ann := Annotations{1: {1: {"template": ""}, 2: {"template": ""}}}
+	templates, err := ParseTemplates(dataset, ann, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if templates.Get(1, 0) != nil || templates.Get(1, 1) == nil || templates.Get(1, 2) == nil || templates.Get(0, 0) != nil {
+		t.Error("Test TestParseTemplates Expected templates of statements with placeholders got ", templates)
+	}
+	if v := templates.Get(1, 2).placeholders[1].values; len(v) != 2 || v[0] != "7" || v[1] != "8" {
+		t.Error("Test TestParseTemplates Expected values of file got ", v)
+	}
+
+	var errors = []string{
+		"SELECT {{int 10 1}};",
+		"SELECT {{int 1}};",
+		"SELECT {{pick file=missing.txt}};",
+		"SELECT {{uuid 4}};",
+		"SELECT {{now-offset 1h 1m}};",
+		"SELECT {{zipf 10 1}};",
+		"SELECT {{zipf 10}};",
+		"SELECT {{random 1 2}};",
+		"SELECT {{id}};",
+		"SELECT {{int 1 2 as=uuid}};",
+		"SELECT {{}};",
+	}
+	for i, stmt := range errors {
+		if _, err = ParseTemplates(map[int][]string{1: {stmt}}, Annotations{1: {0: {"template": ""}}}, dir); err == nil {
+			t.Error("Test TestParseTemplates #", i, "Expected an error for ", stmt)
+		}
+	}
+}
+
+func TestParseTemplatesLiteral(t *testing.T) {
+
+	// Without template annotation, {{...}} is literal text
+	dataset := map[int][]string{1: {`INSERT INTO pages (body) VALUES ('{{title}} {{"a":1}} {{uuid}}');`, "SELECT 1 AS id;"}}
+	templates, err := ParseTemplates(dataset, Annotations{1: {1: {"capture": "id"}}}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl := templates.Get(1, 0); tpl != nil {
+		t.Error("Test TestParseTemplatesLiteral Expected no template got ", tpl)
+	}
+	// A capture without template annotation keeps the statement unchanged
+	stmt, err := templates.Get(1, 1).Fill(NewGenerator(rand.New(rand.NewSource(1))), NewVariables())
+	if err != nil || stmt != dataset[1][1] {
+		t.Error("Test TestParseTemplatesLiteral Expected ", dataset[1][1], " got ", stmt, err)
+	}
+}
+
+func TestTemplateFill(t *testing.T) {
+
+	dataset := map[int][]string{1: {
+		"SELECT {{int 5 7 as=id}}, '{{id}}';",
+		"SELECT '{{pick a b}}', '{{uuid}}', '{{now-offset 1h 2h}}', {{zipf 3 1.5}};",
+		"SELECT {{id}};",
+	}}
+	ann := Annotations{1: {0: {"template": ""}, 1: {"template": ""}, 2: {"template": ""}}}
+	templates, err := ParseTemplates(dataset, ann, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator(rand.New(rand.NewSource(1)))
+	first := regexp.MustCompile(`^SELECT ([5-7]), '([5-7])';$`)
+	second := regexp.MustCompile(`^SELECT '[ab]', '[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}', '(.*)', [1-3];$`)
+
+	for i := 0; i < 100; i++ {
+		vars := NewVariables()
+		m := first.FindStringSubmatch(fill(t, templates.Get(1, 0), g, vars))
+		if m == nil || m[1] != m[2] {
+			t.Fatal("Test TestTemplateFill #", i, "Expected a value shared in the statement got ", m)
+		}
+		stmt := fill(t, templates.Get(1, 1), g, vars)
+		s := second.FindStringSubmatch(stmt)
+		if s == nil {
+			t.Fatal("Test TestTemplateFill #", i, "Unexpected statement ", stmt)
+		}
+		ts, err := time.Parse(nowOffsetFormat, s[1])
+		if err != nil || time.Since(ts) < time.Hour || time.Since(ts) > 2*time.Hour+time.Minute {
+			t.Error("Test TestTemplateFill #", i, "Expected a time between 1h and 2h ago got ", s[1], err)
+		}
+		if v := fill(t, templates.Get(1, 2), g, vars); v != "SELECT "+m[1]+";" {
+			t.Error("Test TestTemplateFill #", i, "Expected a value shared in the transaction got ", v)
+		}
+	}
+}
+
+func TestTemplateZipf(t *testing.T) {
+
+	templates, err := ParseTemplates(map[int][]string{1: {"{{zipf 100 1.5}}"}}, Annotations{1: {0: {"template": ""}}}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator(rand.New(rand.NewSource(1)))
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		v, err := strconv.Atoi(fill(t, templates.Get(1, 0), g, nil))
+		if err != nil || v < 1 || v > 100 {
+			t.Fatal("Test TestTemplateZipf Expected a value between 1 and 100 got ", v, err)
+		}
+		counts[v]++
+	}
+	if counts[1] <= counts[2] || counts[2] <= counts[10] {
+		t.Error("Test TestTemplateZipf Expected first values to be the most frequent got ", counts[1], counts[2], counts[10])
+	}
+}
+
+// fill fills a template which must not fail.
+func fill(t *testing.T, tpl *Template, g *Generator, vars *Variables) string {
+	stmt, err := tpl.Fill(g, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stmt
+}
+
+func TestParseTemplatesCaptures(t *testing.T) {
+
+	dataset := map[int][]string{
+		1: {"BEGIN;", "INSERT INTO orders DEFAULT VALUES RETURNING id, created;", "SELECT {{order}}, '{{created}}';", "COMMIT;"},
+		2: {"SELECT id FROM customers LIMIT 1;"},
+		3: {"SELECT * FROM customers WHERE id = {{customer}};"},
+	}
+	ann := Annotations{
+		1: {1: {"capture": "order:id,created"}, 2: {"template": ""}},
+		2: {0: {"capture": "customer:id", "scope": "session"}},
+		3: {0: {"template": ""}},
+	}
+	templates, err := ParseTemplates(dataset, ann, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []capture{{name: "order", column: "id"}, {name: "created", column: "created"}}
+	if tpl := templates.Get(1, 1); tpl == nil || !tpl.Capturing() || tpl.session || !reflect.DeepEqual(tpl.captures, expected) {
+		t.Error("Test TestParseTemplatesCaptures Expected captures ", expected, " got ", tpl)
+	}
+	if tpl := templates.Get(2, 0); tpl == nil || !tpl.session {
+		t.Error("Test TestParseTemplatesCaptures Expected a session capture got ", tpl)
+	}
+	if tpl := templates.Get(3, 0); tpl == nil || tpl.Capturing() {
+		t.Error("Test TestParseTemplatesCaptures Expected a template without capture got ", tpl)
+	}
+
+	var errors = []struct {
+		dataset map[int][]string
+		ann     Annotations
+	}{
+		// Transaction variable of another transaction
+		{map[int][]string{1: {"SELECT 1 AS id;"}, 2: {"SELECT {{id}};"}},
+			Annotations{1: {0: {"capture": "id"}}, 2: {0: {"template": ""}}}},
+		// Referenced before the capture
+		{map[int][]string{1: {"SELECT {{id}};", "SELECT 1 AS id;"}}, Annotations{1: {0: {"template": ""}, 1: {"capture": "id"}}}},
+		{map[int][]string{1: {"SELECT 1;"}}, Annotations{1: {0: {"capture": "id:"}}}},
+		{map[int][]string{1: {"SELECT 1;"}}, Annotations{1: {0: {"capture": "uuid"}}}},
+		{map[int][]string{1: {"SELECT 1;"}}, Annotations{1: {0: {"capture": "id", "scope": "client"}}}},
+	}
+	for i, test := range errors {
+		if _, err = ParseTemplates(test.dataset, test.ann, "."); err == nil {
+			t.Error("Test TestParseTemplatesCaptures #", i, "Expected an error")
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+
+	vars := NewVariables()
+	vars.Set("id", "1", false)
+	vars.Set("customer", "2", true)
+	vars.Set("id", "3", true)
+	if v, ok := vars.Get("id"); !ok || v != "1" {
+		t.Error("Test TestVariables Expected transaction variable 1 got ", v, ok)
+	}
+	vars.Reset()
+	if v, ok := vars.Get("id"); !ok || v != "3" {
+		t.Error("Test TestVariables Expected session variable 3 after reset got ", v, ok)
+	}
+	vars.Unset("customer")
+	if v, ok := vars.Get("customer"); ok {
+		t.Error("Test TestVariables Expected unset variable got ", v)
+	}
+
+	templates, err := ParseTemplates(map[int][]string{1: {"SELECT {{customer}};"}},
+		Annotations{1: {0: {"template": ""}}, 2: {0: {"capture": "customer", "scope": "session"}}}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = templates.Get(1, 0).Fill(NewGenerator(rand.New(rand.NewSource(1))), vars); err == nil {
+		t.Error("Test TestVariables Expected an error for a variable not captured yet")
+	}
+}
+
+func TestTemplateLimits(t *testing.T) {
+
+	var tests = []struct {
+		stmt     string
+		min, max int64
+	}{
+		{"{{int 0 9223372036854775807}}", 0, math.MaxInt64},
+		{"{{int -1 9223372036854775806}}", -1, math.MaxInt64 - 1},
+		{"{{int -9223372036854775808 9223372036854775807}}", math.MinInt64, math.MaxInt64},
+		{"{{int -9223372036854775808 -9223372036854775808}}", math.MinInt64, math.MinInt64},
+		{"{{int 5 5}}", 5, 5},
+	}
+	g := NewGenerator(rand.New(rand.NewSource(1)))
+	for i, test := range tests {
+		templates, err := ParseTemplates(map[int][]string{1: {test.stmt}}, Annotations{1: {0: {"template": ""}}}, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 100; j++ {
+			v, err := strconv.ParseInt(fill(t, templates.Get(1, 0), g, NewVariables()), 10, 64)
+			if err != nil || v < test.min || v > test.max {
+				t.Fatal("Test TestTemplateLimits #", i, "Expected a value between ", test.min, " and ", test.max, " got ", v, err)
+			}
+		}
+	}
+
+	templates, err := ParseTemplates(map[int][]string{1: {"'{{now-offset 0s 2562047h47m16.854775807s}}'"}},
+		Annotations{1: {0: {"template": ""}}}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(t, templates.Get(1, 0), g, NewVariables())
+	if _, err = ParseTemplates(map[int][]string{1: {"{{now-offset -1h}}"}}, Annotations{1: {0: {"template": ""}}}, "."); err == nil {
+		t.Error("Test TestTemplateLimits Expected an error for a negative offset")
+	}
+}
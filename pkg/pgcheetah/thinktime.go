@@ -18,9 +18,18 @@ type ThinkTime struct {
 // according to a Distribution.
 // Actually there is only one Distribution : uniform.
 func ThinkTimer(t ThinkTime) int {
+	return thinkTimer(t, rand.Float32)
+}
+
+// ThinkTimerRand works like ThinkTimer with the random source of a client.
+func ThinkTimerRand(t ThinkTime, r *rand.Rand) int {
+	return thinkTimer(t, r.Float32)
+}
+
+func thinkTimer(t ThinkTime, random func() float32) int {
 	switch t.Distribution {
 	case "uniform":
-		return int(float32(t.Min) + float32((t.Max+1-t.Min))*rand.Float32())
+		return int(float32(t.Min) + float32((t.Max+1-t.Min))*random())
 		//case "normal":
 		// TODO : add normal distribution generator
 	}
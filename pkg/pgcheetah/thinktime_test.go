@@ -1,6 +1,9 @@
 package pgcheetah
 
-import "testing"
+import (
+	"math/rand"
+	"testing"
+)
 
 func TestThinkTimer(t *testing.T) {
 
@@ -14,3 +17,15 @@ func TestThinkTimer(t *testing.T) {
 		t.Error("Expected 0, got", test)
 	}
 }
+
+func TestThinkTimerRand(t *testing.T) {
+
+	think := ThinkTime{Distribution: "uniform", Min: 2, Max: 500}
+	a, b := rand.New(rand.NewSource(42)), rand.New(rand.NewSource(42))
+	for i := 0; i < 100; i++ {
+		x, y := ThinkTimerRand(think, a), ThinkTimerRand(think, b)
+		if x != y || x < 2 || x > 500 {
+			t.Error("Test TestThinkTimerRand #", i, "Expected same value between 2 and 500, got ", x, y)
+		}
+	}
+}
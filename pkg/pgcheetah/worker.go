@@ -13,70 +13,219 @@ import (
 // The Worker type contains all informations needed to start a WorkerPG.
 // Earch Worker has access to several shared structures through pointers.
 type Worker struct {
+	ActiveClients   *int64           // Number of connected workers, optional
+	ApplicationName string           // application_name of the connection, optional
+	Classes         *XactClasses     // Stats by transaction fingerprint, optional
 	ConnStr         *string          // URI or a DSN connection string
 	Dataset         map[int][]string // Dataset containing all transactions
-	DatasetFraction float64          // Fraction of dataset to use
+	DatasetFraction float64          // Fraction of dataset to use, when Selector is nil
 	DelayXactUs     *int             // Delay to limit global throughput
 	Done            chan bool        // Used to stop workers
+	Errors          *ErrorStats      // Errors counter by SQLSTATE, optional
+	Group           *GroupCounters   // Counters of the client group, optional
+	ID              int              // Client id
 	QueriesCount    *int64           // Global counter for queries
+	QueryLatency    *Histogram       // Latency of each query, optional
+	Seed            int64            // Seed of the client random source, see ClientSeed
+	Selector        *Selector        // Picks transactions, uniform on DatasetFraction when nil
+	Statements      *StatementIndex  // Used to measure latency of each statement, optional
+	States          *ClientStates    // Number of clients by state, optional
+	Stop            chan bool        // Stops one worker when clients are removed, optional
+	Templates       Templates        // Statements with placeholders filled at each execution, optional
 	Think           *ThinkTime       // Used to add random delay between each query
 	Wg              *sync.WaitGroup
-	XactCount       *int64 // Global counter for transactions
+	XactCount       *int64     // Global counter for transactions
+	XactLatency     *Histogram // Latency of each transaction without think time, optional
+	XactLog         *XactLog   // Per transaction log, owned and closed by the worker, optional
 }
 
-// WorkerPG execute all queries from a randomly
-// chosen transaction.
+// WorkerPG execute all queries from a transaction
+// chosen by Selector.
 // If ThinkTime is specified, add a random delay between Think.Min ms
 // and Think.Max ms after each query.
 // Also add a delay after earch transaction to limit global throughput.
 func WorkerPG(w Worker) {
 
-	var randXact, i int
+	var i int
 	cfg, err := pgx.ParseConfig(*w.ConnStr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// Use simple protocol in order to work with pgbouncer
 	cfg.PreferSimpleProtocol = true
+	if w.ApplicationName != "" {
+		cfg.RuntimeParams["application_name"] = w.ApplicationName
+	}
 	db, err := pgx.ConnectConfig(context.Background(), cfg)
 
 	if err != nil {
 		log.Fatal(err, " Connection params : ", string(*w.ConnStr))
 	}
-	setSize := len(w.Dataset)
+	if w.ActiveClients != nil {
+		atomic.AddInt64(w.ActiveClients, 1)
+	}
+	state := ClientDisconnected
+	setState := func(s int) {
+		if w.States != nil {
+			w.States.Move(state, s)
+		}
+		state = s
+	}
+	setState(ClientRunning)
+	// Each client has its own random source: runs are reproducible and
+	// clients do not contend on the lock of the global source
+	rng, sampling := clientRands(w.Seed)
+	if w.XactLog != nil {
+		w.XactLog.Rand = sampling
+	}
+	gen := NewGenerator(rng)
+	vars := NewVariables()
+	selector := w.Selector
+	if selector == nil {
+		selector = &Selector{Strategy: "uniform", size: len(w.Dataset), fraction: w.DatasetFraction}
+	}
+	// sleepUntil waits until t in state s, it returns false when the worker
+	// is stopped meanwhile
+	sleepUntil := func(t time.Time, s int) bool {
+		d := time.Until(t)
+		if d <= 0 {
+			return true
+		}
+		setState(s)
+		defer setState(ClientRunning)
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-w.Done:
+		case <-w.Stop:
+		}
+		return false
+	}
+	var scheduled time.Time // Expected start of next transaction
 	func() {
 		for {
-			if w.DatasetFraction != 1.0 {
-				randXact = int(float64(rand.Intn(setSize)) * w.DatasetFraction)
-			} else {
-				randXact = rand.Intn(setSize)
+			var xactLatency time.Duration
+			var failed bool
+			randXact, ok := selector.Next(rng)
+			if !ok {
+				// Pass of sequential or timestamp selection is done
+				return
+			}
+			// Timestamp replay waits for the original start of the transaction
+			if at, ok := selector.StatementStart(randXact, 0); ok {
+				scheduled = at
+				if !sleepUntil(at, ClientSleeping) {
+					selector.Finish()
+					return
+				}
 			}
+			xactStart := time.Now()
+			vars.Reset()
 			for i = 0; i < len(w.Dataset[randXact]); i++ {
-				_, err = db.Exec(context.Background(), w.Dataset[randXact][i])
-
-				// Ignore SQL error
-				//if err != nil {
-				//	log.Fatal(err)
-				//}
+				// and original gaps between statements instead of think time
+				if at, ok := selector.StatementStart(randXact, i); ok && i > 0 && !sleepUntil(at, ClientThinking) {
+					selector.Finish()
+					return
+				}
+				stmt := w.Dataset[randXact][i]
+				t := w.Templates.Get(randXact, i)
+				if t != nil {
+					stmt, err = t.Fill(gen, vars)
+				}
+				// A statement missing a variable is not run, it is only
+				// counted as an error
+				if t == nil || err == nil {
+					queryStart := time.Now()
+					if t != nil && t.Capturing() {
+						// Rows are read to capture values used by next statements
+						err = t.Query(db, stmt, vars)
+					} else {
+						_, err = db.Exec(context.Background(), stmt)
+					}
+					latency := time.Since(queryStart)
+					xactLatency += latency
+					if w.Statements != nil {
+						w.Statements.Record(randXact, i, latency)
+					}
+					if w.QueryLatency != nil {
+						w.QueryLatency.Observe(latency)
+					}
+					atomic.AddInt64(w.QueriesCount, 1)
+					if w.Group != nil {
+						atomic.AddInt64(&w.Group.Queries, 1)
+					}
+				}
 
-				atomic.AddInt64(w.QueriesCount, 1)
+				// SQL errors are not fatal, they are only counted
+				if err != nil {
+					failed = true
+					if w.Errors != nil {
+						w.Errors.Add(err)
+					}
+					if w.Group != nil {
+						w.Group.Errors.Add(err)
+					}
+				}
 
 				// Avoid ThinkTime calculaton when not necessary
-				if (*w.Think).Max != 0 {
-					time.Sleep(time.Duration(ThinkTimer(*w.Think)) * time.Millisecond)
+				if (*w.Think).Max != 0 && selector.offsets == nil {
+					setState(ClientThinking)
+					time.Sleep(time.Duration(ThinkTimerRand(*w.Think, rng)) * time.Millisecond)
+					setState(ClientRunning)
 				}
 				select {
 				case <-w.Done:
+					selector.Finish()
+					return
+				case <-w.Stop:
+					selector.Finish()
 					return
 				default:
 
 				}
 
 			}
-			time.Sleep(time.Duration(*w.DelayXactUs) * time.Microsecond)
+			selector.Finish()
+			if w.XactLatency != nil {
+				w.XactLatency.Observe(xactLatency)
+			}
+			if w.Group != nil {
+				w.Group.Latency.Observe(xactLatency)
+			}
+			if w.Classes != nil {
+				w.Classes.Record(randXact, xactLatency, failed)
+			}
+			if w.XactLog != nil {
+				var lag time.Duration
+				if !scheduled.IsZero() {
+					lag = xactStart.Sub(scheduled)
+				}
+				if err = w.XactLog.Log(randXact, xactStart, xactLatency, lag, len(w.Dataset[randXact]), failed); err != nil {
+					log.Fatal(err)
+				}
+			}
+			delay := time.Duration(*w.DelayXactUs) * time.Microsecond
+			scheduled = time.Now().Add(delay)
+			setState(ClientSleeping)
+			time.Sleep(delay)
+			setState(ClientRunning)
 			atomic.AddInt64(w.XactCount, 1)
+			if w.Group != nil {
+				atomic.AddInt64(&w.Group.Xact, 1)
+			}
 		}
 	}()
+	if w.ActiveClients != nil {
+		atomic.AddInt64(w.ActiveClients, -1)
+	}
+	setState(ClientDisconnected)
+	if w.XactLog != nil {
+		if err = w.XactLog.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	err = db.Close(context.Background())
 	if err != nil {
 		log.Fatal(err)
@@ -85,54 +234,85 @@ func WorkerPG(w Worker) {
 
 }
 
-// WaitEventCollector collects postgres wait event every 500ms
-// All wait events are stored in a map.
-func WaitEventCollector(we map[string]int, connStr *string, weInterval int) {
+// clientRands returns the random sources of a client seed: the first one
+// picks transactions, think time and template values, the second one
+// samples the transaction log. Sampling has its own stream, so enabling it
+// does not change the replayed sequence.
+func clientRands(seed int64) (*rand.Rand, *rand.Rand) {
+	return rand.New(rand.NewSource(seed)), rand.New(rand.NewSource(ClientSeed(seed, 1)))
+}
 
-	var count int
-	var waitEvent, pgVersion string
-	var waitEventQuery = make(map[string]string, 3)
-	cfg, _ := pgx.ParseConfig(*connStr)
-	// use simple protocol in order to work with pgbouncer
-	cfg.PreferSimpleProtocol = true
-	db, err := pgx.ConnectConfig(context.Background(), cfg)
+// Client states counted by ClientStates
+const (
+	ClientDisconnected = iota - 1
+	ClientRunning      // Executing queries
+	ClientThinking     // Sleeping think time between queries
+	ClientSleeping     // Sleeping delay between transactions set by rate limiter
+)
 
-	if err != nil {
-		log.Fatal(err, " Connection params : ", string(*connStr))
+// ClientStates counts connected clients by state.
+type ClientStates struct {
+	counts [3]int64
+}
+
+// Move changes the state of a client.
+func (cs *ClientStates) Move(from int, to int) {
+	if from != ClientDisconnected {
+		atomic.AddInt64(&cs.counts[from], -1)
 	}
-	defer db.Close(context.Background())
-	if err != nil {
-		log.Fatal(err)
+	if to != ClientDisconnected {
+		atomic.AddInt64(&cs.counts[to], 1)
 	}
+}
 
-	// Wait event query for postgres 9.6
-	waitEventQuery["90600"] = `SELECT
-				  wait_event_type || '-' || wait_event as wait_event,
-				  count(*) as count
-				FROM
-				  pg_stat_activity
-				WHERE
-				   wait_event IS NOT NULL
-				GROUP BY
-				  wait_event_type,
-				  wait_event;
-`
-	// Wait event query for postgres 10
-	waitEventQuery["100000"] = `SELECT
+// Count returns the number of clients in a state.
+func (cs *ClientStates) Count(state int) int64 {
+	return atomic.LoadInt64(&cs.counts[state])
+}
+
+// WaitEvents contains wait events count, it is a Metric of kind sample
+// updated by WaitEventCollector while it can be read by reports.
+type WaitEvents struct {
+	*Metric
+}
+
+// NewWaitEvents returns an empty WaitEvents.
+func NewWaitEvents() *WaitEvents {
+	return &WaitEvents{NewMetric(MetricQuery{Name: "wait_event", Kind: "sample"})}
+}
+
+// Snapshot returns a copy of wait events count.
+func (we *WaitEvents) Snapshot() map[string]int {
+	we.Lock()
+	defer we.Unlock()
+	counts := make(map[string]int, len(we.Values))
+	for w, v := range we.Values {
+		counts[w] = int(v.Sum)
+	}
+	return counts
+}
+
+// WaitEventQuery returns the wait event metric query for a server version.
+// Wait events of client backends are counted by type and name.
+func WaitEventQuery(version int, weInterval int) MetricQuery {
+
+	q := MetricQuery{Name: "wait_event", Interval: weInterval, Kind: "sample"}
+	// Postgres 9.6 has no backend_type
+	if version < 100000 {
+		q.SQL = `SELECT
 				  wait_event_type || '-' || wait_event as wait_event,
 				  count(*) as count
 				FROM
 				  pg_stat_activity
 				WHERE
 				   wait_event IS NOT NULL
-				   AND
-				   backend_type = 'client backend'
 				GROUP BY
 				  wait_event_type,
 				  wait_event;
 `
-	// Wait event query for postgres 11
-	waitEventQuery["110000"] = `SELECT
+		return q
+	}
+	q.SQL = `SELECT
 				  wait_event_type || '-' || wait_event as wait_event,
 				  count(*) as count
 				FROM
@@ -145,28 +325,25 @@ func WaitEventCollector(we map[string]int, connStr *string, weInterval int) {
 				  wait_event_type,
 				  wait_event;
 `
+	return q
+}
+
+// WaitEventCollector collects postgres wait event every weInterval ms
+// with the built-in wait event metric query.
+func WaitEventCollector(we *WaitEvents, connStr *string, weInterval int) {
 
-	err = db.QueryRow(context.Background(), "SELECT (100*(setting::int/100))::text FROM pg_catalog.pg_settings WHERE name IN ('server_version_num') ORDER BY name = 'server_version_num';").Scan(&pgVersion)
+	db, err := Connect(*connStr)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal(err, " Connection params : ", string(*connStr))
 	}
+	defer db.Close(context.Background())
 
-	for {
-		row, err := db.Query(context.Background(), waitEventQuery[pgVersion])
-		if err != nil {
-			log.Fatal(err)
-		}
-		for row.Next() {
-			err = row.Scan(&waitEvent, &count)
-
-			we[waitEvent] += count
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		time.Sleep(time.Duration(weInterval) * time.Millisecond)
-
+	version, err := ServerVersion(db)
+	if err != nil {
+		log.Fatal(err)
 	}
-
+	we.Lock()
+	we.Query = WaitEventQuery(version, weInterval)
+	we.Unlock()
+	collectMetric(db, we.Metric)
 }
@@ -41,7 +41,7 @@ func TestWorkerPG(t *testing.T) {
 }
 func TestWaitEventCollector(t *testing.T) {
 
-	waitEvent := make(map[string]int)
+	waitEvent := NewWaitEvents()
 	go WaitEventCollector(waitEvent, connStr, 500)
 	time.Sleep(time.Duration(1) * time.Second)
 
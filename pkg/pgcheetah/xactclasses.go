@@ -0,0 +1,114 @@
+package pgcheetah
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// XactClass is a transaction shape of the dataset: all dataset transactions
+// with the same normalized statements share its fingerprint.
+type XactClass struct {
+	Fingerprint string
+	Statements  []string // Normalized statements
+	Xacts       int      // Number of dataset transactions of this class
+	Latency     *Histogram
+	errors      int64
+}
+
+// XactClasses groups dataset transactions by fingerprint. Workers use it
+// to measure latency and errors of each class.
+type XactClasses struct {
+	Classes []*XactClass
+	classOf map[int]int // Class of each dataset transaction
+}
+
+// Fingerprint returns the fingerprint of a transaction, a hash of its
+// normalized statements like pg_stat_statements queryid.
+func Fingerprint(queries []string) string {
+	h := fnv.New64a()
+	for _, q := range queries {
+		h.Write([]byte(NormalizeQuery(q)))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// NewXactClasses computes fingerprint of all transactions of a dataset.
+func NewXactClasses(data map[int][]string) *XactClasses {
+	c := XactClasses{classOf: make(map[int]int, len(data))}
+	ids := make(map[string]int)
+	for xact, queries := range data {
+		fp := Fingerprint(queries)
+		id, ok := ids[fp]
+		if !ok {
+			id = len(c.Classes)
+			ids[fp] = id
+			class := XactClass{Fingerprint: fp, Latency: NewHistogram()}
+			for _, q := range queries {
+				class.Statements = append(class.Statements, NormalizeQuery(q))
+			}
+			c.Classes = append(c.Classes, &class)
+		}
+		c.Classes[id].Xacts++
+		c.classOf[xact] = id
+	}
+	return &c
+}
+
+// Record adds latency of dataset transaction xact, failed is true when
+// one of its queries returned an error.
+func (c *XactClasses) Record(xact int, latency time.Duration, failed bool) {
+	class := c.Classes[c.classOf[xact]]
+	class.Latency.Observe(latency)
+	if failed {
+		atomic.AddInt64(&class.errors, 1)
+	}
+}
+
+// Reset clears latencies and errors measured by workers.
+func (c *XactClasses) Reset() {
+	for _, class := range c.Classes {
+		class.Latency.Reset()
+		atomic.StoreInt64(&class.errors, 0)
+	}
+}
+
+// Stats returns stats of executed classes sorted by total time, most
+// expensive first. Share is the percentage of total time of all classes.
+func (c *XactClasses) Stats(t time.Time) []XactClassStat {
+
+	var stats []XactClassStat
+	var total time.Duration
+	for _, class := range c.Classes {
+		total += class.Latency.Sum()
+	}
+	for _, class := range c.Classes {
+		count := class.Latency.Count()
+		if count == 0 {
+			continue
+		}
+		st := XactClassStat{Time: t, Fingerprint: class.Fingerprint, Statements: strings.Join(class.Statements, "; "),
+			DatasetXacts: class.Xacts, Count: count, Errors: atomic.LoadInt64(&class.errors),
+			TotalTime: latencyMs(class.Latency.Sum()), LatencyMean: latencyMs(class.Latency.Mean()),
+			LatencyP50: latencyMs(class.Latency.Percentile(50)), LatencyP95: latencyMs(class.Latency.Percentile(95)),
+			LatencyP99: latencyMs(class.Latency.Percentile(99))}
+		st.ErrorRate = 100 * float64(st.Errors) / float64(count)
+		if total > 0 {
+			st.Share = 100 * float64(class.Latency.Sum()) / float64(total)
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TotalTime > stats[j].TotalTime
+	})
+	return stats
+}
+
+// latencyMs converts a latency in ms.
+func latencyMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,55 @@
+package pgcheetah
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXactClasses(t *testing.T) {
+
+	data := map[int][]string{
+		0: {"BEGIN;", "SELECT * FROM t WHERE id = 1;", "COMMIT;"},
+		1: {"BEGIN;", "SELECT * FROM t WHERE id = 2;", "COMMIT;"},
+		2: {"BEGIN;", "UPDATE t SET x = 1 WHERE id = 3;", "COMMIT;"},
+	}
+	c := NewXactClasses(data)
+	if len(c.Classes) != 2 {
+		t.Fatal("Expected 2 classes, got ", len(c.Classes))
+	}
+	if Fingerprint(data[0]) != Fingerprint(data[1]) || Fingerprint(data[0]) == Fingerprint(data[2]) {
+		t.Error("Unexpected fingerprints", Fingerprint(data[0]), Fingerprint(data[1]), Fingerprint(data[2]))
+	}
+
+	c.Record(0, 1*time.Millisecond, false)
+	c.Record(1, 1*time.Millisecond, true)
+	c.Record(2, 6*time.Millisecond, false)
+
+	var tests = []struct {
+		fingerprint string
+		datasetXact int
+		count       int64
+		errorRate   float64
+		share       float64
+	}{
+		{Fingerprint(data[2]), 1, 1, 0, 75},
+		{Fingerprint(data[0]), 2, 2, 50, 25},
+	}
+
+	stats := c.Stats(time.Now())
+	if len(stats) != len(tests) {
+		t.Fatal("Expected ", len(tests), " stats, got ", len(stats))
+	}
+	for i, test := range tests {
+		st := stats[i]
+		if st.Fingerprint != test.fingerprint || st.DatasetXacts != test.datasetXact || st.Count != test.count ||
+			math.Abs(st.ErrorRate-test.errorRate) > 0.01 || math.Abs(st.Share-test.share) > 0.1 {
+			t.Error("Test TestXactClasses #", i, "Expected ", test, " got ", st)
+		}
+	}
+
+	c.Reset()
+	if stats = c.Stats(time.Now()); len(stats) != 0 {
+		t.Error("Expected no stats after reset, got ", stats)
+	}
+}
@@ -0,0 +1,134 @@
+package pgcheetah
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// XactLog writes one line per transaction of a client, like pgbench -l, or
+// one line per interval when AggInterval is set. Each worker owns its
+// XactLog, so writes are buffered without lock. Lag is the schedule lag:
+// how late a transaction started compared to the delay set by the rate
+// limiter. Latencies and lags are written in µs, formats are described
+// in README.
+type XactLog struct {
+	Client       int
+	SamplingRate float64       // Fraction of transactions logged, between 0 and 1
+	AggInterval  time.Duration // Aggregate transactions by interval when not 0
+	Rand         *rand.Rand    // Random source of sampling, global source when nil
+	w            *bufio.Writer
+	c            io.Closer
+	agg          xactLogAgg
+}
+
+// xactLogAgg aggregates transactions of an interval.
+type xactLogAgg struct {
+	start                           int64 // Interval start, epoch in seconds
+	count, failures                 int64
+	sumLat, sumLat2, minLat, maxLat int64
+	sumLag, sumLag2, minLag, maxLag int64
+}
+
+// NewXactLog creates the transaction log file prefix.client.
+func NewXactLog(prefix string, client int, samplingRate float64, aggInterval time.Duration) (*XactLog, error) {
+	f, err := os.Create(fmt.Sprintf("%s.%d", prefix, client))
+	if err != nil {
+		return nil, err
+	}
+	l := newXactLog(f, client, samplingRate, aggInterval)
+	l.c = f
+	return l, nil
+}
+
+func newXactLog(w io.Writer, client int, samplingRate float64, aggInterval time.Duration) *XactLog {
+	return &XactLog{Client: client, SamplingRate: samplingRate, AggInterval: aggInterval, w: bufio.NewWriterSize(w, 64*1024)}
+}
+
+// Log adds a transaction of dataset index xact started at start.
+func (l *XactLog) Log(xact int, start time.Time, latency time.Duration, lag time.Duration, statements int, failed bool) error {
+
+	lat, lg := latency.Microseconds(), lag.Microseconds()
+	if l.AggInterval == 0 {
+		if l.SamplingRate < 1 {
+			r := rand.Float64
+			if l.Rand != nil {
+				r = l.Rand.Float64
+			}
+			if r() >= l.SamplingRate {
+				return nil
+			}
+		}
+		outcome := "ok"
+		if failed {
+			outcome = "failed"
+		}
+		_, err := fmt.Fprintf(l.w, "%d %d %d %d %d %d %s %d\n", l.Client, xact, start.Unix(), start.Nanosecond()/1000,
+			lat, statements, outcome, lg)
+		return err
+	}
+
+	interval := int64(l.AggInterval / time.Second)
+	if interval < 1 {
+		interval = 1
+	}
+	intervalStart := start.Unix() / interval * interval
+	if l.agg.count > 0 && intervalStart != l.agg.start {
+		if err := l.flushAgg(); err != nil {
+			return err
+		}
+	}
+	a := &l.agg
+	if a.count == 0 {
+		*a = xactLogAgg{start: intervalStart, minLat: lat, maxLat: lat, minLag: lg, maxLag: lg}
+	}
+	a.count++
+	if failed {
+		a.failures++
+	}
+	a.sumLat += lat
+	a.sumLat2 += lat * lat
+	a.sumLag += lg
+	a.sumLag2 += lg * lg
+	if lat < a.minLat {
+		a.minLat = lat
+	}
+	if lat > a.maxLat {
+		a.maxLat = lat
+	}
+	if lg < a.minLag {
+		a.minLag = lg
+	}
+	if lg > a.maxLag {
+		a.maxLag = lg
+	}
+	return nil
+}
+
+// flushAgg writes the aggregated interval.
+func (l *XactLog) flushAgg() error {
+	a := l.agg
+	l.agg = xactLogAgg{}
+	_, err := fmt.Fprintf(l.w, "%d %d %d %d %d %d %d %d %d %d %d\n", a.start, a.count, a.sumLat, a.sumLat2, a.minLat,
+		a.maxLat, a.sumLag, a.sumLag2, a.minLag, a.maxLag, a.failures)
+	return err
+}
+
+// Close writes the last aggregated interval and flushes the log.
+func (l *XactLog) Close() error {
+	if l.agg.count > 0 {
+		if err := l.flushAgg(); err != nil {
+			return err
+		}
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if l.c != nil {
+		return l.c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package pgcheetah
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestXactLog(t *testing.T) {
+
+	start := time.Unix(1556285876, 123456000)
+	var buf bytes.Buffer
+	l := newXactLog(&buf, 3, 1, 0)
+	l.Log(42, start, 1500*time.Microsecond, 20*time.Microsecond, 3, false)
+	l.Log(7, start, 2*time.Millisecond, 0, 5, true)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "3 42 1556285876 123456 1500 3 ok 20\n3 7 1556285876 123456 2000 5 failed 0\n"
+	if buf.String() != expected {
+		t.Error("Expected ", expected, " got ", buf.String())
+	}
+
+	buf.Reset()
+	l = newXactLog(&buf, 3, 1, 10*time.Second)
+	l.Log(1, start, 1*time.Millisecond, 10*time.Microsecond, 3, false)
+	l.Log(2, start.Add(2*time.Second), 3*time.Millisecond, 30*time.Microsecond, 3, true)
+	l.Log(3, start.Add(10*time.Second), 2*time.Millisecond, 0, 3, false)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected = "1556285870 2 4000 10000000 1000 3000 40 1000 10 30 1\n1556285880 1 2000 4000000 2000 2000 0 0 0 0 0\n"
+	if buf.String() != expected {
+		t.Error("Expected ", expected, " got ", buf.String())
+	}
+
+	buf.Reset()
+	l = newXactLog(&buf, 3, 0.000001, 0)
+	for i := 0; i < 100; i++ {
+		l.Log(i, start, time.Millisecond, 0, 1, false)
+	}
+	l.Close()
+	if buf.Len() > 100 {
+		t.Error("Expected sampled log, got ", buf.Len(), " bytes")
+	}
+}
+
+func TestXactLogSamplingSequence(t *testing.T) {
+
+	s, err := NewSelector("weighted", selectorDataset, Annotations{3: {0: {"weight": "4"}}}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transactions picked by a client, with its transaction log sampled or not
+	sequence := func(samplingRate float64) string {
+		rng, sampling := clientRands(42)
+		var buf bytes.Buffer
+		l := newXactLog(&buf, 1, samplingRate, 0)
+		l.Rand = sampling
+		var keys []int
+		for i := 0; i < 50; i++ {
+			k, _ := s.Next(rng)
+			keys = append(keys, k)
+			l.Log(k, time.Now(), time.Millisecond, 0, 3, false)
+		}
+		return fmt.Sprint(keys)
+	}
+	if a, b := sequence(1), sequence(0.5); a != b {
+		t.Error("Test TestXactLogSamplingSequence Expected same sequence with sampling, got ", a, b)
+	}
+}